@@ -0,0 +1,590 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// VerificationPurposeEmailChange tokens prove the user owns the new email address they asked for.
+const VerificationPurposeEmailChange VerificationPurpose = "email_change"
+
+// FormsPolicy is what happens to the forms of a deleted account, with the responses they collected.
+type FormsPolicy string
+
+const (
+	FormsPolicyDelete   FormsPolicy = "delete"   // Purge the forms and their responses for good, trash included
+	FormsPolicyTransfer FormsPolicy = "transfer" // Give the forms and their responses to another user
+)
+
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 32
+)
+
+// usernamePattern is the charset of usernames: they appear in URLs and exports.
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// Errors returned by the account functions.
+var (
+	ErrUsernameTaken  = errors.New("username already taken")
+	ErrEmailTaken     = errors.New("email already used by another account")
+	ErrTransferTarget = errors.New("no account accepted a transfer offer of the forms")
+)
+
+type UsernameChangeRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Code            string `json:"code"` // TOTP or recovery code, for accounts without a password: see confirmIdentity
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type EmailChangeRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// AccountDeletionRequest confirms the deletion of an account and chooses what happens to
+// its forms. Responses the user submitted to the forms of others are kept for their
+// owners, without the link to the account.
+type AccountDeletionRequest struct {
+	Password string      `json:"password"`
+	Code     string      `json:"code"`
+	Forms    FormsPolicy `json:"forms"` // delete, the default, or transfer to the account that accepted the user's FormTransferOffer
+}
+
+// ValidateUsername checks the length and charset of a username.
+func ValidateUsername(username string) error {
+	if n := len(username); n < usernameMinLength || n > usernameMaxLength {
+		return fmt.Errorf("username must be %d to %d characters long", usernameMinLength, usernameMaxLength)
+	}
+	if !usernamePattern.MatchString(username) {
+		return errors.New("username may only contain letters, digits, dots, dashes and underscores")
+	}
+	return nil
+}
+
+// ValidateEmail checks that email is a bare address, without a display name.
+func ValidateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > 254 {
+		return errors.New("email is not a valid address")
+	}
+	return nil
+}
+
+// Validate checks a signup request: email syntax, username charset and the password policy.
+func (request *SignupRequest) Validate() error {
+	if err := ValidateUsername(request.Username); err != nil {
+		return err
+	}
+	if err := ValidateEmail(request.Email); err != nil {
+		return err
+	}
+	return ValidatePassword(request.Password, request.Username, request.Email)
+}
+
+// --- Database Functions ---
+
+// CheckDuplicateUsers fails if users share an email or a username, ignoring case: the
+// unique indexes can't be created until they are told apart. Like the indexes, it ignores
+// deleted users.
+func CheckDuplicateUsers() error {
+	if !DB.Migrator().HasTable(&User{}) {
+		return nil
+	}
+	for _, column := range []string{"email", "username"} {
+		var duplicates []string
+		expression := "lower(" + column + ")"
+		err := DB.Model(&User{}).Where("deleted_at IS NULL").Group(expression).Having("count(*) > 1").Pluck(expression, &duplicates).Error
+		if err != nil {
+			return err
+		}
+		if len(duplicates) > 0 {
+			return fmt.Errorf("several users have the %s %s, ignoring case: change them so each is unique", column, strings.Join(duplicates, ", "))
+		}
+	}
+	return nil
+}
+
+// usernameTaken reports whether another user than userID has the username, ignoring case.
+func usernameTaken(tx *gorm.DB, username string, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := tx.Model(&User{}).Where("lower(username) = lower(?) AND id <> ?", username, userID).Count(&count).Error
+	return count > 0, err
+}
+
+// emailTaken reports whether another user than userID has the email, ignoring case.
+func emailTaken(tx *gorm.DB, email string, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := tx.Model(&User{}).Where("lower(email) = lower(?) AND id <> ?", email, userID).Count(&count).Error
+	return count > 0, err
+}
+
+// ChangeUsername renames a user.
+func ChangeUsername(user *User, username string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		taken, err := usernameTaken(tx, username, user.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrUsernameTaken
+		}
+		err = tx.Model(user).Update("username", username).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrUsernameTaken
+		}
+		return err
+	})
+}
+
+// ChangePassword sets a new password, signs the user out of their other sessions and
+// revokes their API tokens.
+func ChangePassword(user *User, password string, currentSession *uuid.UUID) error {
+	passwordDigest, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password", passwordDigest).Error; err != nil {
+			return err
+		}
+
+		query := tx.Where("user_id = ?", user.ID)
+		if currentSession != nil {
+			query = query.Where("id <> ?", *currentSession)
+		}
+		if err := query.Delete(&UserSession{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&APIToken{}).Error; err != nil {
+			return err
+		}
+
+		return QueueEmail(tx, MailNotification, user.Email, NotificationMail{
+			Username: user.Username,
+			Title:    "Your GForms password was changed",
+			Message:  "The password of your account was just changed: your other devices were signed out and your API tokens were revoked. If you didn't do it, reset your password and check your account.",
+			Link:     frontendLink("/account/login"),
+		})
+	})
+}
+
+// RequestEmailChange emails a confirmation link to the new address of a user. The email
+// of the account only changes once the link is followed. The current address is told
+// about the request.
+func RequestEmailChange(user *User, email string) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		taken, err := emailTaken(tx, email, user.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrEmailTaken
+		}
+
+		if err := tx.Where("user_id = ? AND purpose = ?", user.ID, VerificationPurposeEmailChange).Delete(&Verification{}).Error; err != nil {
+			return err
+		}
+		verification := Verification{
+			UserID:    user.ID,
+			Purpose:   VerificationPurposeEmailChange,
+			TokenHash: hash,
+			NewEmail:  email,
+			ExpiresAt: time.Now().Add(AppConfig.EmailVerificationTTL),
+		}
+		if err := tx.Create(&verification).Error; err != nil {
+			return err
+		}
+
+		err = QueueEmail(tx, MailNotification, email, NotificationMail{
+			Username:  user.Username,
+			Title:     "Confirm your new GForms email address",
+			Message:   fmt.Sprintf("You asked to use this address for your GForms account. Confirm it before %s.", verification.ExpiresAt.Format("Jan 2, 2006 15:04 MST")),
+			Link:      publicLink("/api/account/email/confirm?token=" + url.QueryEscape(token)),
+			LinkLabel: "Confirm my email",
+		})
+		if err != nil {
+			return err
+		}
+		return QueueEmail(tx, MailNotification, user.Email, NotificationMail{
+			Username: user.Username,
+			Title:    "Your GForms email address is changing",
+			Message:  fmt.Sprintf("You asked to use %s for your GForms account instead of this address. If you didn't, change your password.", email),
+		})
+	})
+}
+
+// ConfirmEmailChange changes the email of the user a confirmation token was issued to,
+// using up the token. The other tokens of the user, such as password reset links sent to
+// the old address, are revoked, and the failed sign ins with the old address forgotten.
+func ConfirmEmailChange(token string) (*User, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	var user User
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var verification Verification
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Limit(1).
+			Find(&verification, "token_hash = ? AND purpose = ?", hashToken(token), VerificationPurposeEmailChange).Error
+		if err != nil {
+			return err
+		}
+		if verification.ID == 0 {
+			return ErrInvalidToken
+		}
+		if time.Now().After(verification.ExpiresAt) {
+			return ErrTokenExpired
+		}
+		if err := tx.Delete(&verification).Error; err != nil {
+			return err
+		}
+
+		if err := tx.First(&user, "id = ?", verification.UserID).Error; err != nil {
+			return err
+		}
+		taken, err := emailTaken(tx, verification.NewEmail, user.ID) // Someone may have signed up with it since
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrEmailTaken
+		}
+		oldEmail := user.Email
+		// Following the link proves the address, which is what verification is about
+		err = tx.Model(&user).Updates(map[string]any{"email": verification.NewEmail, "verified": true}).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrEmailTaken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&Verification{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&LoginThrottle{}, "kind = ? AND key = ?", LoginThrottleAccount, normalizeLoginEmail(oldEmail)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteAccount deletes a user for good, with their sessions, tokens, sign in methods
+// and the emails sent to them, but the farewell one. Their forms are deleted or
+// transferred according to the policy: only to the account that accepted their transfer
+// offer, so nobody gets forms they didn't agree to. The responses they submitted to other
+// forms are kept, without the link to the account.
+func DeleteAccount(user *User, policy FormsPolicy) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		userID := user.ID.String()
+		forms := tx.Unscoped().Model(&Form{}).Where("creator_user_id = ?", userID) // Trashed forms too
+
+		switch policy {
+		case FormsPolicyTransfer:
+			recipient, err := acceptedTransferRecipient(tx, user.ID)
+			if err != nil {
+				return err
+			}
+
+			result := forms.Update("creator_user_id", recipient.ID.String())
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				err := QueueEmail(tx, MailNotification, recipient.Email, NotificationMail{
+					Username:  recipient.Username,
+					Title:     "GForms forms were transferred to you",
+					Message:   fmt.Sprintf("%s deleted their account and transferred %d forms to you, with the responses they collected.", user.Username, result.RowsAffected),
+					Link:      frontendLink("/forms"),
+					LinkLabel: "See my forms",
+				})
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			var formIDs []uuid.UUID
+			if err := forms.Pluck("id", &formIDs).Error; err != nil {
+				return err
+			}
+			for _, formID := range formIDs {
+				if err := purgeForm(tx, formID); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Unscoped().Model(&Response{}).Where("respondent_user_id = ?", userID).Update("respondent_user_id", "").Error; err != nil {
+			return err
+		}
+
+		// The addresses the user was emailed at: theirs, and the ones they asked to change to
+		addresses := []string{strings.ToLower(user.Email)}
+		var newEmails []string
+		err := tx.Model(&Verification{}).Where("user_id = ? AND purpose = ?", user.ID, VerificationPurposeEmailChange).Pluck("lower(new_email)", &newEmails).Error
+		if err != nil {
+			return err
+		}
+		addresses = append(addresses, newEmails...)
+		if err := tx.Where(`lower("to") IN ?`, addresses).Delete(&OutboundEmail{}).Error; err != nil {
+			return err
+		}
+
+		for _, model := range []any{&UserSession{}, &APIToken{}, &UserIdentity{}, &TwoFactor{}, &RecoveryCode{}, &Verification{}} {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		err = tx.Where("from_user_id = ? OR to_user_id = ? OR (to_user_id IS NULL AND lower(to_email) = lower(?))", user.ID, user.ID, user.Email).Delete(&FormTransferOffer{}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&LoginThrottle{}, "kind = ? AND key = ?", LoginThrottleAccount, normalizeLoginEmail(user.Email)).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&User{}, "id = ?", user.ID).Error; err != nil {
+			return err
+		}
+
+		return QueueEmail(tx, MailNotification, user.Email, NotificationMail{
+			Username: user.Username,
+			Title:    "Your GForms account was deleted",
+			Message:  "Your account and its data were deleted. Thank you for using GForms.",
+		})
+	})
+}
+
+// --- Handlers ---
+
+// confirmIdentity checks that the user making a change to their account is its owner,
+// not someone who got hold of their session, answering the request if they can't tell.
+// The password is asked for. Accounts without a password, created by single sign-on,
+// enter a code of their authenticator app if they use two-factor authentication, or else
+// must have signed in with single sign-on less than OIDC_REAUTH_MAX_AGE ago. Wrong
+// passwords and codes count as failed sign ins.
+func confirmIdentity(c *gin.Context, user *User, password, code string) bool {
+	if !checkLoginThrottle(c, user.Email) {
+		return false
+	}
+
+	if user.Password != "" {
+		if !checkUserPassword(user, password) {
+			recordLoginFailure(c, user.Email, user, LoginFailureWrongPassword)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Wrong password"})
+			return false
+		}
+		return true
+	}
+
+	enabled, err := TwoFactorEnabled(user.ID)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not confirm the change"})
+		return false
+	}
+	if enabled {
+		if err := VerifySecondFactor(user.ID, code); err != nil {
+			if !errors.Is(err, ErrInvalidCode) {
+				log.Printf("Error checking the second factor of user %s: %v", user.ID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not confirm the change"})
+				return false
+			}
+			recordLoginFailure(c, user.Email, user, LoginFailureWrongCode)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Wrong code"})
+			return false
+		}
+		return true
+	}
+
+	if !recentSingleSignOn(c) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      "Sign in again with single sign-on to confirm",
+			"reauth_url": publicLink("/api/account/oidc/login?reauth=true"),
+		})
+		return false
+	}
+	return true
+}
+
+// changeUsernameHandler handles PUT /api/account/username requests.
+func changeUsernameHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	var usernameRequest UsernameChangeRequest
+	if err := c.ShouldBindJSON(&usernameRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	username := strings.TrimSpace(usernameRequest.Username)
+	if err := ValidateUsername(username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ChangeUsername(user, username); err != nil {
+		if errors.Is(err, ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+			return
+		}
+		log.Printf("Error changing username of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change username"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "email": user.Email, "message": "Username changed"})
+}
+
+// changePasswordHandler handles PUT /api/account/password requests. The other sessions
+// of the user are signed out.
+func changePasswordHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	var passwordRequest PasswordChangeRequest
+	if err := c.ShouldBindJSON(&passwordRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidatePassword(passwordRequest.NewPassword, user.Username, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !confirmIdentity(c, user, passwordRequest.CurrentPassword, passwordRequest.Code) {
+		return
+	}
+
+	if err := ChangePassword(user, passwordRequest.NewPassword, currentSessionID(c)); err != nil {
+		log.Printf("Error changing password of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, your other devices were signed out"})
+}
+
+// changeEmailHandler handles PUT /api/account/email requests. The email changes once the
+// link sent to the new address is followed.
+func changeEmailHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	var emailRequest EmailChangeRequest
+	if err := c.ShouldBindJSON(&emailRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := strings.TrimSpace(emailRequest.Email)
+	if err := ValidateEmail(email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.EqualFold(email, user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This is already your email"})
+		return
+	}
+	if !confirmIdentity(c, user, emailRequest.Password, emailRequest.Code) {
+		return
+	}
+
+	if err := RequestEmailChange(user, email); err != nil {
+		if errors.Is(err, ErrEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+			return
+		}
+		log.Printf("Error requesting an email change for user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "A confirmation link was sent to " + email + ", your email changes once you follow it"})
+}
+
+// confirmEmailChangeHandler handles GET /api/account/email/confirm?token=... requests, made
+// by following the link sent to the new address. It redirects to the sign in page of the
+// frontend with the result: ?email_change=success, expired, invalid, taken or error.
+func confirmEmailChangeHandler(c *gin.Context) {
+	result := "success"
+	if _, err := ConfirmEmailChange(c.Query("token")); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidToken):
+			result = "invalid"
+		case errors.Is(err, ErrTokenExpired):
+			result = "expired"
+		case errors.Is(err, ErrEmailTaken):
+			result = "taken"
+		default:
+			log.Printf("Error confirming email change: %v", err)
+			result = "error"
+		}
+	}
+
+	c.Redirect(http.StatusSeeOther, frontendLink("/account/login?email_change="+result))
+}
+
+// deleteAccountHandler handles DELETE /api/account requests, signing the user out for good.
+func deleteAccountHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	var deletionRequest AccountDeletionRequest
+	if err := c.ShouldBindJSON(&deletionRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch deletionRequest.Forms {
+	case "":
+		deletionRequest.Forms = FormsPolicyDelete
+	case FormsPolicyDelete:
+	case FormsPolicyTransfer:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "forms must be delete or transfer"})
+		return
+	}
+	if !confirmIdentity(c, user, deletionRequest.Password, deletionRequest.Code) {
+		return
+	}
+
+	if err := DeleteAccount(user, deletionRequest.Forms); err != nil {
+		if errors.Is(err, ErrTransferTarget) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Your forms can only be transferred to an account that accepted your transfer offer"})
+			return
+		}
+		log.Printf("Error deleting account of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete account"})
+		return
+	}
+	log.Printf("Account of user %s deleted, forms policy %s", user.ID, deletionRequest.Forms)
+
+	session := sessions.Default(c)
+	session.Clear()
+	session.Options(sessions.Options{Path: "/", MaxAge: -1})
+	if err := session.Save(); err != nil {
+		log.Printf("Error ending session of deleted user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
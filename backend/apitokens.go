@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// TokenScope is a permission granted to an API token.
+type TokenScope string
+
+const (
+	ScopeFormsRead      TokenScope = "forms:read"      // List and read forms and their versions
+	ScopeFormsWrite     TokenScope = "forms:write"     // Create, edit, publish and delete forms
+	ScopeResponsesRead  TokenScope = "responses:read"  // Read and export responses
+	ScopeResponsesWrite TokenScope = "responses:write" // Delete and restore responses
+)
+
+var tokenScopes = []TokenScope{ScopeFormsRead, ScopeFormsWrite, ScopeResponsesRead, ScopeResponsesWrite}
+
+// apiTokenPrefix starts every API token, so leaked tokens are easy to recognize.
+const apiTokenPrefix = "gft_"
+
+// maxAPITokens bounds the number of API tokens of a user.
+const maxAPITokens = 20
+
+// contextAPITokenKey is the key of the API token authenticate stores in the Gin context,
+// for requests authenticated with one.
+const contextAPITokenKey = "api_token"
+
+var ErrTooManyAPITokens = errors.New("too many API tokens")
+
+// --- Entities ---
+
+// APIToken is a personal access token, used by scripts instead of a session. Only the
+// hash of the token is stored: the token itself is shown once, when it is created.
+type APIToken struct {
+	ID         uuid.UUID      `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID     uuid.UUID      `json:"-" gorm:"type:uuid;index"`
+	Name       string         `json:"name"`
+	Hint       string         `json:"hint"` // The first characters of the token, to tell tokens apart
+	TokenHash  string         `json:"-" gorm:"uniqueIndex"`
+	Scopes     []TokenScope   `json:"scopes" gorm:"type:jsonb;serializer:json"`
+	ExpiresAt  *time.Time     `json:"expires_at"` // The token never expires if not set
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+type APITokenRequest struct {
+	Name      string       `json:"name" binding:"required"`
+	Scopes    []TokenScope `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time   `json:"expires_at"`
+}
+
+// CreatedAPIToken is the answer to the creation of a token, the only one that carries the token.
+type CreatedAPIToken struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// HasScope reports whether the token grants the scope.
+func (token *APIToken) HasScope(scope TokenScope) bool {
+	return slices.Contains(token.Scopes, scope)
+}
+
+// Validate checks a token creation request.
+func (request *APITokenRequest) Validate(now time.Time) error {
+	if strings.TrimSpace(request.Name) == "" {
+		return errors.New("name must not be empty")
+	}
+	if len(request.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range request.Scopes {
+		if !slices.Contains(tokenScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+// --- Database Functions ---
+
+// CreateAPIToken creates a token for a user and returns it with the token in clear.
+func CreateAPIToken(userID uuid.UUID, request APITokenRequest) (*CreatedAPIToken, error) {
+	secret, _, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	secret = apiTokenPrefix + secret
+
+	scopes := slices.Clone(request.Scopes)
+	slices.Sort(scopes)
+	token := APIToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(request.Name),
+		Hint:      secret[:len(apiTokenPrefix)+4],
+		TokenHash: hashToken(secret),
+		Scopes:    slices.Compact(scopes),
+		ExpiresAt: request.ExpiresAt,
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&APIToken{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= maxAPITokens {
+			return ErrTooManyAPITokens
+		}
+		return tx.Create(&token).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CreatedAPIToken{APIToken: token, Token: secret}, nil
+}
+
+// GetAPITokens returns the tokens of a user, most recent first.
+func GetAPITokens(userID uuid.UUID) ([]APIToken, error) {
+	var tokens []APIToken
+	err := DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeAPIToken deletes a token of a user. Requests made with it fail right away.
+func RevokeAPIToken(userID, tokenID uuid.UUID) error {
+	result := DB.Where("user_id = ?", userID).Delete(&APIToken{}, "id = ?", tokenID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TokenUser returns the user of a valid API token and the token, or nils if the token
+// is unknown, revoked or expired. The last use of the token is recorded, once a minute at most.
+func TokenUser(secret string) (*User, *APIToken, error) {
+	var token APIToken
+	if err := DB.Limit(1).Find(&token, "token_hash = ?", hashToken(secret)).Error; err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if token.ID == uuid.Nil || (token.ExpiresAt != nil && !now.Before(*token.ExpiresAt)) {
+		return nil, nil, nil
+	}
+
+	var user User
+	if err := DB.Limit(1).Find(&user, "id = ?", token.UserID).Error; err != nil {
+		return nil, nil, err
+	}
+	if user.ID == uuid.Nil {
+		return nil, nil, nil
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		token.LastUsedAt = &now
+		if err := DB.Model(&token).UpdateColumn("last_used_at", now).Error; err != nil {
+			log.Printf("Error recording the use of API token %s: %v", token.ID, err)
+		}
+	}
+	return &user, &token, nil
+}
+
+// --- Middleware ---
+
+// bearerToken returns the token of an "Authorization: Bearer" header, if the request has one.
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// currentAPIToken returns the API token the request was authenticated with, or nil for sessions.
+func currentAPIToken(c *gin.Context) *APIToken {
+	if token, ok := c.Get(contextAPITokenKey); ok {
+		return token.(*APIToken)
+	}
+	return nil
+}
+
+// requireScope rejects requests authenticated with an API token that lacks the scope.
+// Requests made with a session aren't restricted.
+func requireScope(scope TokenScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := currentAPIToken(c); token != nil && !token.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "The API token lacks the " + string(scope) + " scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireSession rejects requests authenticated with an API token, for the routes that
+// manage the account itself: a token can't be used to create more tokens.
+func requireSession(c *gin.Context) {
+	if currentAPIToken(c) != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API tokens can't be used for this, sign in instead"})
+		return
+	}
+	c.Next()
+}
+
+// --- Handlers ---
+
+// listAPITokensHandler handles GET /api/account/tokens requests.
+func listAPITokensHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	tokens, err := GetAPITokens(user.ID)
+	if err != nil {
+		log.Printf("Error retrieving API tokens of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving API tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// createAPITokenHandler handles POST /api/account/tokens requests.
+func createAPITokenHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	var tokenRequest APITokenRequest
+	if err := c.ShouldBindJSON(&tokenRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or incomplete JSON request: " + err.Error()})
+		return
+	}
+	if err := tokenRequest.Validate(time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := CreateAPIToken(user.ID, tokenRequest)
+	if err != nil {
+		if errors.Is(err, ErrTooManyAPITokens) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("You can't have more than %d API tokens, revoke one first", maxAPITokens)})
+			return
+		}
+		log.Printf("Error creating API token for user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create API token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, token)
+}
+
+// revokeAPITokenHandler handles DELETE /api/account/tokens/:tokenId requests.
+func revokeAPITokenHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	tokenID, err := uuid.Parse(c.Param("tokenId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID format"})
+		return
+	}
+
+	if err := RevokeAPIToken(user.ID, tokenID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API token not found"})
+			return
+		}
+		log.Printf("Error revoking API token %s: %v", tokenID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke API token"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
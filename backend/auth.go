@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// sessionUserIDKey is the session value holding the ID of the signed in user.
+const sessionUserIDKey = "user_id"
+
+// contextUserKey is the key of the user authenticate stores in the Gin context.
+const contextUserKey = "user"
+
+// startSession signs the user in on the request's session.
+func startSession(c *gin.Context, user *User) error {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(sessionUserIDKey, user.ID.String())
+	return session.Save()
+}
+
+// sessionUser returns the user signed in with the request's session, or nil if there is none.
+// Sessions of users that no longer exist are treated as signed out.
+func sessionUser(c *gin.Context) (*User, error) {
+	value, ok := sessions.Default(c).Get(sessionUserIDKey).(string)
+	if !ok {
+		return nil, nil
+	}
+	userID, err := uuid.Parse(value)
+	if err != nil {
+		return nil, nil
+	}
+
+	var user User
+	if err := DB.Limit(1).Find(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == uuid.Nil {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// --- Middleware ---
+
+// authenticate resolves the user signed in with the request's session, if any, once
+// per request and stores it in the Gin context. Handlers read it back with currentUser.
+// Requests with an "Authorization: Bearer" header are authenticated with the API token
+// instead, and rejected if it isn't valid.
+// It doesn't reject anonymous requests: routes that need a user add requireUser.
+func authenticate(c *gin.Context) {
+	if secret, ok := bearerToken(c); ok {
+		user, token, err := TokenUser(secret)
+		if err != nil {
+			log.Printf("Error retrieving API token user: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving user"})
+			return
+		}
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API token"})
+			return
+		}
+		c.Set(contextUserKey, user)
+		c.Set(contextAPITokenKey, token)
+		c.Next()
+		return
+	}
+
+	user, err := sessionUser(c)
+	if err != nil {
+		log.Printf("Error retrieving session user: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving user"})
+		return
+	}
+	if user != nil {
+		c.Set(contextUserKey, user)
+	}
+	c.Next()
+}
+
+// requireUser rejects requests made without a signed in user. It runs after authenticate.
+func requireUser(c *gin.Context) {
+	if currentUser(c) == nil {
+		abortUnauthorized(c)
+		return
+	}
+	c.Next()
+}
+
+// abortUnauthorized is the response of every API route to a request that needs a signed in user.
+func abortUnauthorized(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Not signed in"})
+}
+
+// currentUser returns the signed in user resolved by authenticate, or nil.
+func currentUser(c *gin.Context) *User {
+	if user, ok := c.Get(contextUserKey); ok {
+		return user.(*User)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// contextFormKey is the key of the form authorizeForm stores in the Gin context.
+const contextFormKey = "form"
+
+// FormAction is something a user may want to do with a form.
+type FormAction string
+
+const (
+	FormActionRespond FormAction = "respond" // View the form and submit responses to it
+	FormActionManage  FormAction = "manage"  // Edit the form, change its status and read its responses
+)
+
+// CanActOnForm is the authorization policy for forms: it reports whether the user,
+// nil when nobody is signed in, may perform the action on the form.
+// Owners can do anything; everybody else can only see and answer forms that are not drafts.
+func CanActOnForm(user *User, form *Form, action FormAction) bool {
+	isOwner := user != nil && form.CreatorUserID == user.ID.String()
+
+	switch action {
+	case FormActionRespond:
+		return isOwner || form.Status != FormStatusDraft
+	case FormActionManage:
+		return isOwner
+	}
+	return false
+}
+
+// --- Middleware ---
+
+// authorizeForm loads the form named by the :formId route parameter and checks that the
+// current user may perform the action on it. Handlers read the form back with currentForm.
+// Forms the user can't see are reported as not found, so drafts don't leak.
+func authorizeForm(action FormAction) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		formID := c.Param("formId")
+		if _, err := uuid.Parse(formID); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid form ID format"})
+			return
+		}
+
+		form, err := GetFormByID(formID)
+		if err != nil {
+			log.Printf("Error retrieving form %s: %v", formID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving form"})
+			return
+		}
+
+		user := currentUser(c)
+		if form == nil || !CanActOnForm(user, form, FormActionRespond) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+			return
+		}
+		if !CanActOnForm(user, form, action) {
+			if user == nil {
+				abortUnauthorized(c)
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You are not allowed to " + string(action) + " this form"})
+			return
+		}
+
+		c.Set(contextFormKey, form)
+		c.Next()
+	}
+}
+
+// currentForm returns the form loaded by authorizeForm.
+func currentForm(c *gin.Context) *Form {
+	if form, ok := c.Get(contextFormKey); ok {
+		return form.(*Form)
+	}
+	return nil
+}
+
+// requireAdmin rejects requests of users who aren't admins. It runs after requireUser.
+func requireAdmin(c *gin.Context) {
+	if !currentUser(c).IsAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Reserved to admins"})
+		return
+	}
+	c.Next()
+}
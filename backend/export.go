@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ExportedQuestion describes a column of a responses export.
+type ExportedQuestion struct {
+	ID   uuid.UUID `json:"id"`
+	Text string    `json:"text"`
+	Type string    `json:"type"`
+}
+
+// ExportedResponse is a response flattened for export. Answers are keyed by
+// question ID: single-valued answers are strings, multi-valued answers are
+// arrays. Choice IDs are replaced by the labels of the version that was answered.
+type ExportedResponse struct {
+	ID               uuid.UUID      `json:"id"`
+	RespondentUserID string         `json:"respondent_user_id"`
+	FormVersion      int            `json:"form_version"`
+	SubmittedAt      time.Time      `json:"submitted_at"`
+	Answers          map[string]any `json:"answers"`
+}
+
+// ResponsesExport is the document returned by the responses export.
+type ResponsesExport struct {
+	FormID     uuid.UUID          `json:"form_id"`
+	Title      string             `json:"title"`
+	ExportedAt time.Time          `json:"exported_at"`
+	Questions  []ExportedQuestion `json:"questions"`
+	Responses  []ExportedResponse `json:"responses"`
+}
+
+// ExportResponses builds the export of the responses collected by a form.
+func ExportResponses(form *Form) (*ResponsesExport, error) {
+	responses, err := GetResponsesByFormID(form.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	versions, err := GetFormVersions(form.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// Questions as they were in each version, to resolve choice labels.
+	currentQuestions := questionsByID(form.Questions)
+	versionQuestions := make(map[int]map[uuid.UUID]*Question, len(versions))
+	for i := range versions {
+		versionQuestions[versions[i].Version] = questionsByID(versions[i].Questions)
+	}
+
+	export := &ResponsesExport{
+		FormID:     form.ID,
+		Title:      form.Title,
+		ExportedAt: time.Now(),
+		Questions:  make([]ExportedQuestion, 0, len(form.Questions)),
+		Responses:  make([]ExportedResponse, 0, len(responses)),
+	}
+	for _, q := range form.Questions {
+		export.Questions = append(export.Questions, ExportedQuestion{ID: q.ID, Text: q.Text, Type: q.Type})
+	}
+
+	for _, response := range responses {
+		questions, ok := versionQuestions[response.FormVersion]
+		if !ok {
+			questions = currentQuestions
+		}
+
+		exported := ExportedResponse{
+			ID:               response.ID,
+			RespondentUserID: response.RespondentUserID,
+			FormVersion:      response.FormVersion,
+			SubmittedAt:      response.CreatedAt,
+			Answers:          make(map[string]any, len(response.Answers)),
+		}
+		for _, answer := range response.Answers {
+			question := questions[answer.QuestionID]
+			if question == nil {
+				question = currentQuestions[answer.QuestionID]
+			}
+			exported.Answers[answer.QuestionID.String()] = exportAnswer(question, &answer)
+		}
+		export.Responses = append(export.Responses, exported)
+	}
+
+	return export, nil
+}
+
+// exportAnswer returns the exported value of an answer: an array for multi-valued
+// questions, a string otherwise, with choice IDs replaced by their labels.
+func exportAnswer(question *Question, answer *Answer) any {
+	label := func(value string) string {
+		if question != nil {
+			if choice, ok := question.Config.ChoiceByID(value); ok {
+				return choice.Label
+			}
+		}
+		return value
+	}
+
+	multiValue := len(answer.Values) > 0
+	if question != nil {
+		if questionType, ok := LookupQuestionType(question.Type); ok {
+			multiValue = questionType.MultiValue()
+		}
+	}
+	if !multiValue {
+		return label(answer.Value)
+	}
+
+	values := make([]string, 0, len(answer.Values))
+	for _, value := range answer.Values {
+		values = append(values, label(value))
+	}
+	return values
+}
+
+func questionsByID(questions []Question) map[uuid.UUID]*Question {
+	byID := make(map[uuid.UUID]*Question, len(questions))
+	for i := range questions {
+		byID[questions[i].ID] = &questions[i]
+	}
+	return byID
+}
+
+// --- Handlers ---
+
+// exportFormResponsesHandler handles GET /forms/:formId/responses/export requests.
+func exportFormResponsesHandler(c *gin.Context) {
+	formID := c.Param("formId")
+	form := currentForm(c) // Loaded and authorized by authorizeForm
+
+	export, err := ExportResponses(form)
+	if err != nil {
+		log.Printf("Error exporting responses for form %s: %v", formID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error exporting responses"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="form-%s-responses.json"`, form.ID))
+	c.JSON(http.StatusOK, export)
+}
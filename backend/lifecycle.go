@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FormStatus is the lifecycle state of a form.
+type FormStatus string
+
+const (
+	FormStatusDraft     FormStatus = "draft"     // Being built: editable, not accepting responses
+	FormStatusScheduled FormStatus = "scheduled" // Published ahead of its opening time: waiting for the scheduler
+	FormStatusPublished FormStatus = "published" // Live: accepting responses, not editable
+	FormStatusClosed    FormStatus = "closed"    // Finished: neither editable nor accepting responses
+)
+
+// FormTransition is a named move between lifecycle states.
+type FormTransition struct {
+	Name string
+	From []FormStatus
+	To   FormStatus
+}
+
+// A published form goes back to draft to be edited; the edit then records
+// a new version, and responses collected so far stay pinned to the old one.
+var (
+	PublishTransition   = FormTransition{Name: "publish", From: []FormStatus{FormStatusDraft}, To: FormStatusPublished}
+	UnpublishTransition = FormTransition{Name: "unpublish", From: []FormStatus{FormStatusScheduled, FormStatusPublished, FormStatusClosed}, To: FormStatusDraft}
+	CloseTransition     = FormTransition{Name: "close", From: []FormStatus{FormStatusScheduled, FormStatusPublished}, To: FormStatusClosed}
+	ReopenTransition    = FormTransition{Name: "reopen", From: []FormStatus{FormStatusClosed}, To: FormStatusPublished}
+)
+
+// ErrInvalidTransition is returned when a form cannot move to the requested status.
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// ErrFormNotEditable is returned when changing the content of a form that is not a draft.
+var ErrFormNotEditable = errors.New("only draft forms can be edited, unpublish the form first")
+
+// Allows reports whether the transition can be applied to a form in the given status.
+func (t FormTransition) Allows(status FormStatus) bool {
+	for _, from := range t.From {
+		if from == status {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEditable reports whether the form's content and questions may be changed.
+func (form *Form) IsEditable() bool {
+	return form.Status == FormStatusDraft
+}
+
+// --- Database Functions ---
+
+// TransitionForm applies a lifecycle transition to a form if its current status allows it,
+// and returns the form as GetFormByID loads it.
+func TransitionForm(id string, transition FormTransition) (*Form, error) {
+	var form Form
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Questions").
+			First(&form, "id = ?", id).Error; err != nil {
+			return err
+		}
+
+		if !transition.Allows(form.Status) {
+			return fmt.Errorf("%w: cannot %s a %s form", ErrInvalidTransition, transition.Name, form.Status)
+		}
+
+		form.Status = transition.To
+		if transition.To == FormStatusPublished {
+			if len(form.Questions) == 0 {
+				return fmt.Errorf("%w: a form without questions cannot be published", ErrInvalidTransition)
+			}
+			now := time.Now()
+			if form.ClosesAt != nil && !now.Before(*form.ClosesAt) {
+				return fmt.Errorf("%w: the form's window has ended, update its schedule first", ErrInvalidTransition)
+			}
+			if form.MaxResponses != nil {
+				var count int64
+				if err := tx.Model(&Response{}).Where("form_id = ?", form.ID).Count(&count).Error; err != nil {
+					return err
+				}
+				if count >= int64(*form.MaxResponses) {
+					return fmt.Errorf("%w: the form reached its response limit, update its schedule first", ErrInvalidTransition)
+				}
+			}
+			// Forms whose window hasn't started yet wait for the scheduler to open them.
+			form.Status = form.liveStatus(now)
+		}
+
+		return tx.Model(&form).Update("status", form.Status).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Answer the form as GET /forms/:formId does, with its sections in order
+	loaded, err := GetFormByID(id)
+	if err == nil && loaded == nil {
+		return nil, gorm.ErrRecordNotFound // Deleted meanwhile
+	}
+	return loaded, err
+}
+
+// --- Handlers ---
+
+// transitionFormHandler returns a handler for POST /forms/:formId/{publish,unpublish,close,reopen}.
+func transitionFormHandler(transition FormTransition) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		formID := c.Param("formId")
+
+		form, err := TransitionForm(formID, transition)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+				return
+			}
+			if errors.Is(err, ErrInvalidTransition) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			log.Printf("Error applying %s to form %s: %v", transition.Name, formID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update form status"})
+			return
+		}
+
+		log.Printf("Form %s is now %s", form.ID, form.Status)
+		c.JSON(http.StatusOK, form)
+	}
+}
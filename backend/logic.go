@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConditionOperator compares the answer to a question with a value.
+type ConditionOperator string
+
+const (
+	OpAnswered    ConditionOperator = "answered"     // The question has a non-empty answer
+	OpNotAnswered ConditionOperator = "not_answered" // The question has no answer, or an empty one
+	OpEquals      ConditionOperator = "equals"       // The answer is the value (a choice ID for choice questions)
+	OpNotEquals   ConditionOperator = "not_equals"   // The answer is not the value, or there is no answer
+	OpContains    ConditionOperator = "contains"     // The selection includes the value, or the text contains it
+	OpGreater     ConditionOperator = "gt"           // Numbers and dates only
+	OpGreaterEq   ConditionOperator = "gte"          // Numbers and dates only
+	OpLess        ConditionOperator = "lt"           // Numbers and dates only
+	OpLessEq      ConditionOperator = "lte"          // Numbers and dates only
+)
+
+// maxConditionDepth bounds the nesting of all/any/not groups.
+const maxConditionDepth = 5
+
+// Condition is a boolean expression over the answers given to earlier questions.
+// It is either a group (All, Any or Not) or a comparison on a single question.
+type Condition struct {
+	All        []Condition       `json:"all,omitempty"`
+	Any        []Condition       `json:"any,omitempty"`
+	Not        *Condition        `json:"not,omitempty"`
+	QuestionID *uuid.UUID        `json:"question_id,omitempty"`
+	Operator   ConditionOperator `json:"op,omitempty"`
+	Value      string            `json:"value,omitempty"`
+}
+
+// JumpRule sends the respondent forward once a question is answered: when the
+// condition holds, the questions up to the target are skipped (and hidden).
+// Exactly one target must be set.
+type JumpRule struct {
+	If           Condition  `json:"if"`
+	ToQuestionID *uuid.UUID `json:"to_question_id,omitempty"` // A later question
+	ToSectionID  *uuid.UUID `json:"to_section_id,omitempty"`  // The first question of a later section
+	ToEnd        bool       `json:"to_end,omitempty"`         // Skip every remaining question
+}
+
+// reached reports whether the respondent lands on the question when following the jump.
+func (jump *JumpRule) reached(question *Question) bool {
+	switch {
+	case jump.ToQuestionID != nil:
+		return *jump.ToQuestionID == question.ID
+	case jump.ToSectionID != nil:
+		return question.SectionID != nil && *jump.ToSectionID == *question.SectionID
+	}
+	return false
+}
+
+// Evaluate reports whether the condition holds for the given answers, keyed by question ID.
+func (condition *Condition) Evaluate(answers map[uuid.UUID]*Answer) bool {
+	switch {
+	case len(condition.All) > 0:
+		for i := range condition.All {
+			if !condition.All[i].Evaluate(answers) {
+				return false
+			}
+		}
+		return true
+	case len(condition.Any) > 0:
+		for i := range condition.Any {
+			if condition.Any[i].Evaluate(answers) {
+				return true
+			}
+		}
+		return false
+	case condition.Not != nil:
+		return !condition.Not.Evaluate(answers)
+	case condition.QuestionID != nil:
+		return condition.compare(answers[*condition.QuestionID])
+	}
+	return false
+}
+
+func (condition *Condition) compare(answer *Answer) bool {
+	answered := answer != nil && !answer.IsEmpty()
+
+	switch condition.Operator {
+	case OpAnswered:
+		return answered
+	case OpNotAnswered:
+		return !answered
+	case OpEquals:
+		return answered && answer.Value == condition.Value
+	case OpNotEquals:
+		return !answered || answer.Value != condition.Value
+	case OpContains:
+		if !answered {
+			return false
+		}
+		if len(answer.Values) > 0 {
+			return slices.Contains(answer.Values, condition.Value)
+		}
+		return strings.Contains(answer.Value, condition.Value)
+	case OpGreater, OpGreaterEq, OpLess, OpLessEq:
+		if !answered {
+			return false
+		}
+		order, ok := compareOrdered(answer.Value, condition.Value)
+		if !ok {
+			return false
+		}
+		switch condition.Operator {
+		case OpGreater:
+			return order > 0
+		case OpGreaterEq:
+			return order >= 0
+		case OpLess:
+			return order < 0
+		default:
+			return order <= 0
+		}
+	}
+	return false
+}
+
+// compareOrdered compares two numbers, or two dates formatted as DateLayout.
+func compareOrdered(a, b string) (int, bool) {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	_, errA = time.Parse(DateLayout, a)
+	_, errB = time.Parse(DateLayout, b)
+	if errA == nil && errB == nil {
+		// Dates in DateLayout compare chronologically as strings.
+		return strings.Compare(a, b), true
+	}
+	return 0, false
+}
+
+// ApplyLogic walks the questions in order and evaluates their display conditions
+// and jumps against the submitted answers. It returns the questions shown to the
+// respondent and the answers to keep: answers to hidden questions are dropped.
+// Answers that don't belong to any question are kept, so validation reports them.
+func ApplyLogic(questions []Question, answers []Answer) ([]Question, []Answer) {
+	submitted := make(map[uuid.UUID]*Answer, len(answers))
+	for i := range answers {
+		if _, seen := submitted[answers[i].QuestionID]; !seen {
+			submitted[answers[i].QuestionID] = &answers[i]
+		}
+	}
+
+	shown := make([]Question, 0, len(questions))
+	shownIDs := make(map[uuid.UUID]bool, len(questions))
+	given := make(map[uuid.UUID]*Answer, len(answers)) // Answers to the questions shown so far
+
+	var skipTo *JumpRule
+	for _, question := range questions {
+		if skipTo != nil {
+			if !skipTo.reached(&question) {
+				continue
+			}
+			skipTo = nil
+		}
+		if question.ShowIf != nil && !question.ShowIf.Evaluate(given) {
+			continue
+		}
+
+		shown = append(shown, question)
+		shownIDs[question.ID] = true
+		if answer, ok := submitted[question.ID]; ok {
+			given[question.ID] = answer
+		}
+
+		for i := range question.Jumps {
+			if question.Jumps[i].If.Evaluate(given) {
+				skipTo = &question.Jumps[i]
+				break
+			}
+		}
+	}
+
+	known := make(map[uuid.UUID]bool, len(questions))
+	for _, question := range questions {
+		known[question.ID] = true
+	}
+	kept := make([]Answer, 0, len(answers))
+	for _, answer := range answers {
+		if shownIDs[answer.QuestionID] || !known[answer.QuestionID] {
+			kept = append(kept, answer)
+		}
+	}
+
+	return shown, kept
+}
+
+// ValidateLogic checks the display conditions and jumps of a list of questions,
+// in the order they are shown: conditions may only refer to earlier questions
+// and jumps may only go forward.
+func ValidateLogic(questions []Question) error {
+	position := make(map[uuid.UUID]int, len(questions))
+	sectionStart := make(map[uuid.UUID]int) // Position of the first question of each section
+	for i, question := range questions {
+		if question.ID != uuid.Nil { // Questions without a client ID can't be referred to yet
+			position[question.ID] = i
+		}
+		if question.SectionID != nil {
+			if _, ok := sectionStart[*question.SectionID]; !ok {
+				sectionStart[*question.SectionID] = i
+			}
+		}
+	}
+
+	for i := range questions {
+		question := &questions[i]
+		earlier := func(id uuid.UUID) (*Question, error) {
+			at, ok := position[id]
+			if !ok {
+				return nil, fmt.Errorf("refers to unknown question %s", id)
+			}
+			if at >= i {
+				return nil, fmt.Errorf("refers to question %d, which is not an earlier question", at+1)
+			}
+			return &questions[at], nil
+		}
+
+		if question.ShowIf != nil {
+			if err := validateCondition(question.ShowIf, earlier, 1); err != nil {
+				return fmt.Errorf("question %d: show_if %v", i+1, err)
+			}
+		}
+
+		// A question's jumps may also test the question itself.
+		answeredSoFar := func(id uuid.UUID) (*Question, error) {
+			if id == question.ID {
+				return question, nil
+			}
+			return earlier(id)
+		}
+		for j := range question.Jumps {
+			jump := &question.Jumps[j]
+			if err := validateCondition(&jump.If, answeredSoFar, 1); err != nil {
+				return fmt.Errorf("question %d: jump %d %v", i+1, j+1, err)
+			}
+			targets := 0
+			for _, set := range []bool{jump.ToQuestionID != nil, jump.ToSectionID != nil, jump.ToEnd} {
+				if set {
+					targets++
+				}
+			}
+			if targets != 1 {
+				return fmt.Errorf("question %d: jump %d must set exactly one of to_question_id, to_section_id or to_end", i+1, j+1)
+			}
+			if jump.ToQuestionID != nil {
+				at, ok := position[*jump.ToQuestionID]
+				if !ok || at <= i {
+					return fmt.Errorf("question %d: jump %d must go to a later question", i+1, j+1)
+				}
+			}
+			if jump.ToSectionID != nil {
+				// Sections without questions can't be jumped to: there is nothing to land on.
+				at, ok := sectionStart[*jump.ToSectionID]
+				if !ok || at <= i {
+					return fmt.Errorf("question %d: jump %d must go to a later section with questions", i+1, j+1)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateCondition(condition *Condition, lookup func(uuid.UUID) (*Question, error), depth int) error {
+	if depth > maxConditionDepth {
+		return fmt.Errorf("is nested more than %d levels deep", maxConditionDepth)
+	}
+
+	forms := 0
+	for _, set := range []bool{len(condition.All) > 0, len(condition.Any) > 0, condition.Not != nil, condition.QuestionID != nil} {
+		if set {
+			forms++
+		}
+	}
+	if forms != 1 {
+		return errors.New("must set exactly one of all, any, not or question_id")
+	}
+
+	for _, group := range [][]Condition{condition.All, condition.Any} {
+		for i := range group {
+			if err := validateCondition(&group[i], lookup, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if condition.Not != nil {
+		return validateCondition(condition.Not, lookup, depth+1)
+	}
+	if condition.QuestionID == nil {
+		return nil
+	}
+
+	question, err := lookup(*condition.QuestionID)
+	if err != nil {
+		return err
+	}
+	questionType, _ := LookupQuestionType(question.Type)
+	isChoice := len(question.Config.Choices) > 0
+
+	switch condition.Operator {
+	case OpAnswered, OpNotAnswered:
+		if condition.Value != "" {
+			return fmt.Errorf("operator %q takes no value", condition.Operator)
+		}
+	case OpEquals, OpNotEquals:
+		if questionType != nil && questionType.MultiValue() {
+			return fmt.Errorf("operator %q cannot be used on a multi-valued question, use contains", condition.Operator)
+		}
+		if isChoice {
+			if _, ok := question.Config.ChoiceByID(condition.Value); !ok {
+				return fmt.Errorf("value %q is not a choice of the question", condition.Value)
+			}
+		}
+	case OpContains:
+		if condition.Value == "" {
+			return fmt.Errorf("operator %q needs a value", condition.Operator)
+		}
+		if isChoice {
+			if _, ok := question.Config.ChoiceByID(condition.Value); !ok {
+				return fmt.Errorf("value %q is not a choice of the question", condition.Value)
+			}
+		}
+	case OpGreater, OpGreaterEq, OpLess, OpLessEq:
+		if question.Type != "number" && question.Type != "date" {
+			return fmt.Errorf("operator %q can only be used on number and date questions", condition.Operator)
+		}
+		if _, ok := compareOrdered(condition.Value, condition.Value); !ok {
+			return fmt.Errorf("value %q must be a number or a date", condition.Value)
+		}
+	default:
+		return fmt.Errorf("has unknown operator %q", condition.Operator)
+	}
+	return nil
+}
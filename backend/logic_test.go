@@ -0,0 +1,191 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func ref(id uuid.UUID) *uuid.UUID {
+	return &id
+}
+
+func TestApplyLogic(t *testing.T) {
+	pet := Question{ID: uuid.New(), Text: "Do you have a pet?", Type: "radio"}
+	kind := Question{ID: uuid.New(), Text: "Which kind?", Type: "text",
+		ShowIf: &Condition{QuestionID: ref(pet.ID), Operator: OpEquals, Value: "yes"}}
+	age := Question{ID: uuid.New(), Text: "Age", Type: "number"}
+	email := Question{ID: uuid.New(), Text: "Email", Type: "email"}
+	last := Question{ID: uuid.New(), Text: "Comments", Type: "textarea"}
+	age.Jumps = []JumpRule{{If: Condition{QuestionID: ref(age.ID), Operator: OpLess, Value: "18"}, ToQuestionID: ref(last.ID)}}
+	questions := []Question{pet, kind, age, email, last}
+
+	stranger := uuid.New()
+
+	tests := []struct {
+		name    string
+		answers []Answer
+		shown   []uuid.UUID
+		kept    []uuid.UUID
+	}{
+		{
+			name:    "condition holds",
+			answers: []Answer{{QuestionID: pet.ID, Value: "yes"}, {QuestionID: kind.ID, Value: "cat"}},
+			shown:   []uuid.UUID{pet.ID, kind.ID, age.ID, email.ID, last.ID},
+			kept:    []uuid.UUID{pet.ID, kind.ID},
+		},
+		{
+			name:    "hidden question answers are dropped",
+			answers: []Answer{{QuestionID: pet.ID, Value: "no"}, {QuestionID: kind.ID, Value: "cat"}},
+			shown:   []uuid.UUID{pet.ID, age.ID, email.ID, last.ID},
+			kept:    []uuid.UUID{pet.ID},
+		},
+		{
+			name:    "jump skips questions",
+			answers: []Answer{{QuestionID: age.ID, Value: "12"}, {QuestionID: email.ID, Value: "kid@example.com"}, {QuestionID: last.ID, Value: "hi"}},
+			shown:   []uuid.UUID{pet.ID, age.ID, last.ID},
+			kept:    []uuid.UUID{age.ID, last.ID},
+		},
+		{
+			name:    "jump not taken",
+			answers: []Answer{{QuestionID: age.ID, Value: "40"}, {QuestionID: email.ID, Value: "me@example.com"}},
+			shown:   []uuid.UUID{pet.ID, age.ID, email.ID, last.ID},
+			kept:    []uuid.UUID{age.ID, email.ID},
+		},
+		{
+			name:    "unknown questions are kept for validation",
+			answers: []Answer{{QuestionID: stranger, Value: "x"}},
+			shown:   []uuid.UUID{pet.ID, age.ID, email.ID, last.ID},
+			kept:    []uuid.UUID{stranger},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shown, kept := ApplyLogic(questions, tt.answers)
+			if ids := questionIDs(shown); !reflect.DeepEqual(ids, tt.shown) {
+				t.Errorf("shown = %v, want %v", ids, tt.shown)
+			}
+			var keptIDs []uuid.UUID
+			for _, answer := range kept {
+				keptIDs = append(keptIDs, answer.QuestionID)
+			}
+			if !reflect.DeepEqual(keptIDs, tt.kept) {
+				t.Errorf("kept = %v, want %v", keptIDs, tt.kept)
+			}
+		})
+	}
+}
+
+func TestApplyLogicToEnd(t *testing.T) {
+	first := Question{ID: uuid.New(), Text: "Continue?", Type: "radio"}
+	first.Jumps = []JumpRule{{If: Condition{QuestionID: ref(first.ID), Operator: OpEquals, Value: "no"}, ToEnd: true}}
+	second := Question{ID: uuid.New(), Text: "Why?", Type: "text", IsRequired: true}
+
+	shown, kept := ApplyLogic([]Question{first, second}, []Answer{{QuestionID: first.ID, Value: "no"}, {QuestionID: second.ID, Value: "because"}})
+	if ids := questionIDs(shown); !reflect.DeepEqual(ids, []uuid.UUID{first.ID}) {
+		t.Errorf("shown = %v, want only the first question", ids)
+	}
+	if len(kept) != 1 || kept[0].QuestionID != first.ID {
+		t.Errorf("kept = %v, want only the first answer", kept)
+	}
+}
+
+func TestValidateLogic(t *testing.T) {
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	answered := func(id uuid.UUID) Condition {
+		return Condition{QuestionID: ref(id), Operator: OpAnswered}
+	}
+	questions := func(edit func(qs []Question)) []Question {
+		qs := []Question{
+			{ID: a, Text: "A", Type: "text"},
+			{ID: b, Text: "B", Type: "text"},
+			{ID: c, Text: "C", Type: "text"},
+		}
+		edit(qs)
+		return qs
+	}
+
+	tests := []struct {
+		name      string
+		questions []Question
+		wantErr   string
+	}{
+		{
+			name: "forward jump and earlier condition",
+			questions: questions(func(qs []Question) {
+				qs[0].Jumps = []JumpRule{{If: answered(a), ToQuestionID: ref(c)}}
+				cond := answered(a)
+				qs[1].ShowIf = &cond
+			}),
+		},
+		{
+			name: "jump back is a cycle",
+			questions: questions(func(qs []Question) {
+				qs[2].Jumps = []JumpRule{{If: answered(c), ToQuestionID: ref(a)}}
+			}),
+			wantErr: "question 3: jump 1 must go to a later question",
+		},
+		{
+			name: "jump to itself is a cycle",
+			questions: questions(func(qs []Question) {
+				qs[1].Jumps = []JumpRule{{If: answered(b), ToQuestionID: ref(b)}}
+			}),
+			wantErr: "question 2: jump 1 must go to a later question",
+		},
+		{
+			name: "jump to an unknown question",
+			questions: questions(func(qs []Question) {
+				qs[0].Jumps = []JumpRule{{If: answered(a), ToQuestionID: ref(uuid.New())}}
+			}),
+			wantErr: "question 1: jump 1 must go to a later question",
+		},
+		{
+			name: "jump without a target",
+			questions: questions(func(qs []Question) {
+				qs[0].Jumps = []JumpRule{{If: answered(a)}}
+			}),
+			wantErr: "question 1: jump 1 must set exactly one of",
+		},
+		{
+			name: "jump with two targets",
+			questions: questions(func(qs []Question) {
+				qs[0].Jumps = []JumpRule{{If: answered(a), ToQuestionID: ref(c), ToEnd: true}}
+			}),
+			wantErr: "question 1: jump 1 must set exactly one of",
+		},
+		{
+			name: "condition on a later question",
+			questions: questions(func(qs []Question) {
+				cond := answered(c)
+				qs[1].ShowIf = &cond
+			}),
+			wantErr: "question 2: show_if",
+		},
+		{
+			name: "condition on itself",
+			questions: questions(func(qs []Question) {
+				cond := answered(b)
+				qs[1].ShowIf = &cond
+			}),
+			wantErr: "question 2: show_if",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLogic(tt.questions)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateLogic() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateLogic() = %v, want prefix %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// LoginThrottleKind tells what a LoginThrottle counts the failed sign ins of.
+type LoginThrottleKind string
+
+const (
+	LoginThrottleAccount LoginThrottleKind = "account" // An email, whether or not an account uses it
+	LoginThrottleIP      LoginThrottleKind = "ip"      // A client address, whatever the emails tried
+)
+
+// Why a sign in attempt failed, as recorded in LoginAttempt.
+const (
+	LoginFailureUnknownEmail  = "unknown_email"
+	LoginFailureWrongPassword = "wrong_password"
+	LoginFailureWrongCode     = "wrong_code" // Wrong second factor
+	LoginFailureUnverified    = "unverified"
+	LoginFailureLocked        = "locked" // Refused without checking the password
+)
+
+// --- Entities ---
+
+// LoginThrottle counts the recent failed sign ins of an email or of a client address. Past
+// LOGIN_MAX_FAILURES (LOGIN_IP_MAX_FAILURES for addresses), sign ins are refused for
+// LOGIN_LOCKOUT, doubling with every further failure up to LOGIN_MAX_LOCKOUT.
+type LoginThrottle struct {
+	Kind          LoginThrottleKind `json:"kind" gorm:"primaryKey;type:varchar(16)"`
+	Key           string            `json:"key" gorm:"primaryKey"`
+	Failures      int               `json:"failures" gorm:"not null;default:0"`
+	LastFailureAt time.Time         `json:"last_failure_at"`
+	LockedUntil   *time.Time        `json:"locked_until"`
+}
+
+// LoginAttempt is the audit record of a failed sign in.
+type LoginAttempt struct {
+	ID        uuid.UUID  `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Email     string     `json:"email" gorm:"index"`
+	UserID    *uuid.UUID `json:"user_id" gorm:"type:uuid;index"` // nil when no account uses the email
+	IPAddress string     `json:"ip_address" gorm:"index"`
+	UserAgent string     `json:"user_agent"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at" gorm:"index"`
+}
+
+// LoginLockedError is returned while sign ins are refused.
+type LoginLockedError struct {
+	Until time.Time
+}
+
+func (err *LoginLockedError) Error() string {
+	return "too many failed sign ins, locked until " + err.Until.Format(time.RFC3339)
+}
+
+// normalizeLoginEmail returns the key failed sign ins are counted by for an email.
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// lockoutDuration returns how long sign ins are refused after the given number of
+// failures in a row, or 0 if they aren't.
+func lockoutDuration(failures, maxFailures int) time.Duration {
+	if maxFailures <= 0 || failures < maxFailures {
+		return 0
+	}
+	lockout := AppConfig.LoginLockout
+	for i := maxFailures; i < failures && lockout < AppConfig.LoginMaxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, AppConfig.LoginMaxLockout)
+}
+
+// --- Database Functions ---
+
+// CheckLoginAllowed returns a LoginLockedError if sign ins with the email, or from the
+// address, are refused at the moment.
+func CheckLoginAllowed(email, ip string, now time.Time) error {
+	var throttles []LoginThrottle
+	err := DB.Where("(kind = ? AND key = ?) OR (kind = ? AND key = ?)",
+		LoginThrottleAccount, normalizeLoginEmail(email), LoginThrottleIP, ip).
+		Where("locked_until > ?", now).Find(&throttles).Error
+	if err != nil {
+		return err
+	}
+
+	var until time.Time
+	for _, throttle := range throttles {
+		if throttle.LockedUntil.After(until) {
+			until = *throttle.LockedUntil
+		}
+	}
+	if until.IsZero() {
+		return nil
+	}
+	return &LoginLockedError{Until: until}
+}
+
+// RecordLoginFailure audits a failed sign in and counts it against the email and the
+// address. Failures older than LOGIN_FAILURE_WINDOW are forgotten. Refused attempts
+// are only audited.
+func RecordLoginFailure(email string, userID *uuid.UUID, ip, userAgent, reason string) error {
+	now := time.Now()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		attempt := LoginAttempt{
+			Email:     normalizeLoginEmail(email),
+			UserID:    userID,
+			IPAddress: ip,
+			UserAgent: userAgent,
+			Reason:    reason,
+		}
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		if reason == LoginFailureLocked {
+			return nil
+		}
+
+		if err := countLoginFailure(tx, LoginThrottleAccount, attempt.Email, AppConfig.LoginMaxFailures, now); err != nil {
+			return err
+		}
+		return countLoginFailure(tx, LoginThrottleIP, ip, AppConfig.LoginIPMaxFailures, now)
+	})
+}
+
+func countLoginFailure(tx *gorm.DB, kind LoginThrottleKind, key string, maxFailures int, now time.Time) error {
+	if key == "" {
+		return nil
+	}
+
+	// Create the row if needed, then lock it, so concurrent failures are all counted
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&LoginThrottle{Kind: kind, Key: key}).Error; err != nil {
+		return err
+	}
+	var throttle LoginThrottle
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&throttle, "kind = ? AND key = ?", kind, key).Error; err != nil {
+		return err
+	}
+
+	if now.Sub(throttle.LastFailureAt) > AppConfig.LoginFailureWindow {
+		throttle.Failures = 0
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = now
+	if lockout := lockoutDuration(throttle.Failures, maxFailures); lockout > 0 {
+		lockedUntil := now.Add(lockout)
+		throttle.LockedUntil = &lockedUntil
+		log.Printf("Sign ins for %s %s locked for %s after %d failures", kind, key, lockout, throttle.Failures)
+	}
+	return tx.Save(&throttle).Error
+}
+
+// ResetLoginFailures forgets the failed sign ins with an email, after a successful one.
+// Failures from the address are kept: signing in to one account doesn't vouch for the
+// other emails tried from there.
+func ResetLoginFailures(email string) error {
+	return DB.Delete(&LoginThrottle{}, "kind = ? AND key = ?", LoginThrottleAccount, normalizeLoginEmail(email)).Error
+}
+
+// UnlockUser lifts the lockout of a user's account and forgets their failed sign ins.
+func UnlockUser(userID uuid.UUID) (*User, error) {
+	var user User
+	if err := DB.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if err := ResetLoginFailures(user.Email); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetLoginAttempts returns the most recent failed sign ins of a user.
+func GetLoginAttempts(userID uuid.UUID, limit int) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := DB.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&attempts).Error
+	return attempts, err
+}
+
+// PurgeLoginAttempts deletes the audit records older than the retention, and the
+// counters of failures that are forgotten.
+func PurgeLoginAttempts(now time.Time, retention time.Duration) error {
+	result := DB.Where("created_at < ?", now.Add(-retention)).Delete(&LoginAttempt{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Login attempt purge: deleted %d audit records", result.RowsAffected)
+	}
+
+	return DB.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-AppConfig.LoginFailureWindow), now).
+		Delete(&LoginThrottle{}).Error
+}
+
+// StartLoginAttemptPurger runs PurgeLoginAttempts every interval in the background.
+func StartLoginAttemptPurger(interval, retention time.Duration) {
+	if interval <= 0 {
+		log.Println("Login attempt purge disabled (SESSION_PURGE_INTERVAL is not positive)")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			if err := PurgeLoginAttempts(now, retention); err != nil {
+				log.Printf("Error purging login attempts: %v", err)
+			}
+		}
+	}()
+	log.Printf("Login attempt purge started, keeping failed sign ins for %s", retention)
+}
+
+// --- Handlers ---
+
+// checkLoginThrottle refuses the sign in with a 429 if the email or the address is locked
+// out, and reports whether it may go on.
+func checkLoginThrottle(c *gin.Context, email string) bool {
+	err := CheckLoginAllowed(email, c.ClientIP(), time.Now())
+	if err == nil {
+		return true
+	}
+
+	var locked *LoginLockedError
+	if errors.As(err, &locked) {
+		if err := RecordLoginFailure(email, nil, c.ClientIP(), c.Request.UserAgent(), LoginFailureLocked); err != nil {
+			log.Printf("Error recording refused sign in: %v", err)
+		}
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(locked.Until).Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed sign ins, try again later"})
+		return false
+	}
+
+	log.Printf("Error checking sign in throttling: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign in"})
+	return false
+}
+
+// recordLoginFailure counts a failed sign in of the request, logging errors: the answer
+// to the client doesn't depend on it.
+func recordLoginFailure(c *gin.Context, email string, user *User, reason string) {
+	var userID *uuid.UUID
+	if user != nil && user.ID != uuid.Nil {
+		userID = &user.ID
+	}
+	if err := RecordLoginFailure(email, userID, c.ClientIP(), c.Request.UserAgent(), reason); err != nil {
+		log.Printf("Error recording failed sign in: %v", err)
+	}
+}
+
+// unlockUserHandler handles POST /api/admin/users/:userId/unlock requests.
+func unlockUserHandler(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	user, err := UnlockUser(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error unlocking user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not unlock user"})
+		return
+	}
+
+	log.Printf("User %s unlocked by admin %s", user.ID, currentUser(c).ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked, " + user.Email + " can sign in again"})
+}
+
+// listLoginAttemptsHandler handles GET /api/admin/users/:userId/login-attempts requests,
+// answering the 100 most recent failed sign ins of the user.
+func listLoginAttemptsHandler(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	attempts, err := GetLoginAttempts(userID, 100)
+	if err != nil {
+		log.Printf("Error retrieving login attempts of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving login attempts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, attempts)
+}
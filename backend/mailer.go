@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// smtpTimeout bounds the time spent delivering an email to the SMTP server, connection included.
+const smtpTimeout = 30 * time.Second
+
+// Email is a message ready to be delivered.
+type Email struct {
+	To      string
+	Subject string
+	Text    string // Plain text body
+	HTML    string // HTML body, sent as an alternative to the text one when set
+}
+
+// Mailer delivers emails. Emails are not sent directly but queued with QueueEmail,
+// which retries the deliveries that fail.
+type Mailer interface {
+	Send(email *Email) error
+}
+
+// mailer is the Mailer configured in AppConfig, set up by InitMailer.
+var mailer Mailer
+
+// InitMailer sets up the Mailer configured in AppConfig.
+func InitMailer() {
+	var err error
+	if mailer, err = NewMailer(AppConfig); err != nil {
+		log.Fatalf("Failed to set up the mailer: %v", err)
+	}
+	log.Printf("Mailer set up, sending emails with the %s driver", AppConfig.MailDriver)
+}
+
+// NewMailer returns the Mailer selected by MAIL_DRIVER: smtp, file or log.
+func NewMailer(config Config) (Mailer, error) {
+	switch config.MailDriver {
+	case "smtp":
+		var auth smtp.Auth
+		if config.SMTPUsername != "" {
+			auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+		}
+		return &SMTPMailer{Addr: net.JoinHostPort(config.SMTPHost, config.SMTPPort), From: config.MailFrom, Auth: auth}, nil
+	case "file":
+		if err := os.MkdirAll(config.MailDir, 0o755); err != nil {
+			return nil, err
+		}
+		return &FileMailer{Dir: config.MailDir, From: config.MailFrom}, nil
+	case "log", "":
+		return LogMailer{}, nil
+	}
+	return nil, fmt.Errorf("unknown MAIL_DRIVER %q, use smtp, file or log", config.MailDriver)
+}
+
+// SMTPMailer sends emails through an SMTP server, upgrading to TLS when the server
+// supports it. A local catcher such as MailHog can be used in development.
+type SMTPMailer struct {
+	Addr string    // host:port of the server
+	From string    // Sender address, optionally with a name: "GForms <no-reply@example.com>"
+	Auth smtp.Auth // nil for servers that don't require authentication
+}
+
+func (m *SMTPMailer) Send(email *Email) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	message, err := buildMessage(m.From, email)
+	if err != nil {
+		return err
+	}
+
+	// As smtp.SendMail, which can't time out
+	conn, err := net.DialTimeout("tcp", m.Addr, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	host, _, _ := net.SplitHostPort(m.Addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.Auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(m.Auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(email.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileMailer writes every email to a .eml file in a directory, for development and tests.
+// Files are named after the time and the recipient, reduced to characters that are safe
+// in file names.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(email *Email) error {
+	message, err := buildMessage(m.From, email)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), safeFileName(email.To))
+	return os.WriteFile(filepath.Join(m.Dir, name), message, 0o644)
+}
+
+// safeFileName replaces what could escape a directory or upset a file system, path
+// separators and dots included, so an address can be part of a file name.
+func safeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '@' || r == '-' || r == '_' || r == '+' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// LogMailer writes the recipient and subject of every email to the log. It is the
+// default, so development works without a mail server. Bodies are not logged, as they
+// hold sign in, reset and verification links: use the file driver to read them.
+type LogMailer struct{}
+
+func (LogMailer) Send(email *Email) error {
+	log.Printf("Email to %s: %s (body not logged, set MAIL_DRIVER=file to keep it)", email.To, email.Subject)
+	return nil
+}
+
+// buildMessage formats an email as a MIME message, with its text and HTML bodies as alternatives.
+func buildMessage(from string, email *Email) ([]byte, error) {
+	if strings.ContainsAny(email.To, "\r\n") || strings.ContainsAny(from, "\r\n") {
+		return nil, fmt.Errorf("invalid address %q", email.To)
+	}
+
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", from)
+	header("To", email.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", email.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(from))
+	header("MIME-Version", "1.0")
+
+	if email.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		return buf.Bytes(), writeQuotedPrintable(&buf, email.Text)
+	}
+
+	parts := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// messageID returns a unique Message-ID in the domain of the sender.
+func messageID(from string) string {
+	domain := "localhost"
+	if address, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(address.Address, "@"); at >= 0 {
+			domain = address.Address[at+1:]
+		}
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(buf), domain)
+}
+
+// --- Templates ---
+
+// Every email has a text template, NAME.txt.tmpl, which also defines the "subject"
+// block, and an HTML template, NAME.html.tmpl, rendered inside layout.html.tmpl.
+//
+//go:embed templates/mail
+var mailTemplateFiles embed.FS
+
+// Names of the email templates.
+const (
+	MailVerification  = "verification"
+	MailPasswordReset = "password_reset"
+	MailNotification  = "notification"
+)
+
+// VerificationMail is the data of the verification email.
+type VerificationMail struct {
+	Username  string
+	Link      string
+	ExpiresAt time.Time
+}
+
+// PasswordResetMail is the data of the password reset email.
+type PasswordResetMail struct {
+	Username  string
+	Link      string
+	ExpiresAt time.Time
+}
+
+// NotificationMail is the data of a notification: a short message, with an optional link.
+type NotificationMail struct {
+	Username  string
+	Title     string
+	Message   string
+	Link      string
+	LinkLabel string
+}
+
+// RenderEmail renders the named template for a recipient.
+func RenderEmail(name, to string, data any) (*Email, error) {
+	text, err := texttemplate.ParseFS(mailTemplateFiles, "templates/mail/"+name+".txt.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	html, err := htmltemplate.ParseFS(mailTemplateFiles, "templates/mail/layout.html.tmpl", "templates/mail/"+name+".html.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	email := &Email{To: to}
+	var buf bytes.Buffer
+	if err := text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return nil, err
+	}
+	email.Subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := text.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	email.Text = buf.String()
+
+	buf.Reset()
+	if err := html.ExecuteTemplate(&buf, "layout.html.tmpl", data); err != nil {
+		return nil, err
+	}
+	email.HTML = buf.String()
+
+	return email, nil
+}
+
+// publicLink returns an absolute link to a path of the API, using PUBLIC_URL.
+func publicLink(path string) string {
+	return strings.TrimRight(AppConfig.PublicURL, "/") + path
+}
+
+// frontendLink returns an absolute link to a page of the frontend, using FRONTEND_URL.
+func frontendLink(path string) string {
+	return strings.TrimRight(AppConfig.FrontendURL, "/") + path
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/google/uuid"
+)
+
+// OutboundEmailStatus is where an email is in the send queue.
+type OutboundEmailStatus string
+
+const (
+	OutboundEmailPending OutboundEmailStatus = "pending" // Waiting for its next attempt
+	OutboundEmailSending OutboundEmailStatus = "sending" // Claimed by an instance of the backend, see mailClaimTimeout
+	OutboundEmailSent    OutboundEmailStatus = "sent"
+	OutboundEmailFailed  OutboundEmailStatus = "failed" // Gave up after MAIL_MAX_ATTEMPTS attempts
+)
+
+// mailBatchSize bounds the number of emails sent by a single run of the queue.
+const mailBatchSize = 20
+
+// maxMailRetryDelay bounds the delay between two attempts at sending an email.
+const maxMailRetryDelay = time.Hour
+
+// mailClaimTimeout is how long an email claimed for sending waits before it is claimed
+// again, in case the instance that claimed it stopped. It outlasts a batch sent to an
+// unresponsive server.
+const mailClaimTimeout = mailBatchSize*smtpTimeout + 5*time.Minute
+
+// --- Entities ---
+
+// OutboundEmail is an email in the send queue. Emails are rendered when they are
+// queued, so a retry sends exactly the same message. The bodies, which may hold links
+// with tokens, are blanked once the email is sent or given up on.
+type OutboundEmail struct {
+	ID            uuid.UUID           `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	To            string              `json:"to"`
+	Subject       string              `json:"subject"`
+	TextBody      string              `json:"-"`
+	HTMLBody      string              `json:"-"`
+	Status        OutboundEmailStatus `json:"status" gorm:"type:varchar(16);not null;default:pending;index"`
+	Attempts      int                 `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt time.Time           `json:"next_attempt_at" gorm:"index"`
+	LastError     string              `json:"last_error"`
+	SentAt        *time.Time          `json:"sent_at"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+}
+
+// --- Database Functions ---
+
+// QueueEmail renders the named template for a recipient and queues it for delivery.
+// Passing the transaction that creates what the email is about means the email is only
+// sent if the transaction commits.
+func QueueEmail(tx *gorm.DB, name, to string, data any) error {
+	email, err := RenderEmail(name, to, data)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&OutboundEmail{
+		To:            email.To,
+		Subject:       email.Subject,
+		TextBody:      email.Text,
+		HTMLBody:      email.HTML,
+		Status:        OutboundEmailPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// claimQueuedEmails marks the emails whose next attempt is due as being sent, and
+// returns them. Locked rows are skipped, so several instances of the backend can share
+// the queue. Emails claimed by an instance that stopped are claimed again once
+// mailClaimTimeout has passed.
+func claimQueuedEmails(now time.Time) ([]OutboundEmail, error) {
+	var emails []OutboundEmail
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?", []OutboundEmailStatus{OutboundEmailPending, OutboundEmailSending}, now).
+			Order("next_attempt_at").Limit(mailBatchSize).Find(&emails).Error
+		if err != nil || len(emails) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(emails))
+		for i := range emails {
+			ids[i] = emails[i].ID
+			emails[i].Attempts++
+		}
+		return tx.Model(&OutboundEmail{}).Where("id IN ?", ids).Updates(map[string]any{
+			"status":          OutboundEmailSending,
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": now.Add(mailClaimTimeout),
+		}).Error
+	})
+	return emails, err
+}
+
+// DeliverQueuedEmails sends the emails whose next attempt is due. Failed attempts
+// are retried with an exponential backoff, until maxAttempts is reached. Emails are
+// claimed first and sent outside of any transaction, and the result of each is
+// recorded on its own, so a slow server holds no lock and a failed update doesn't
+// send the rest of the batch again.
+func DeliverQueuedEmails(now time.Time, maxAttempts int) error {
+	emails, err := claimQueuedEmails(now)
+	if err != nil {
+		return err
+	}
+
+	for i := range emails {
+		email := &emails[i]
+
+		updates := map[string]any{}
+		err := mailer.Send(&Email{To: email.To, Subject: email.Subject, Text: email.TextBody, HTML: email.HTMLBody})
+		switch {
+		case err == nil:
+			updates["status"] = OutboundEmailSent
+			updates["sent_at"] = time.Now()
+			updates["last_error"] = ""
+		case email.Attempts >= maxAttempts:
+			log.Printf("Giving up sending email %s to %s after %d attempts: %v", email.ID, email.To, email.Attempts, err)
+			updates["status"] = OutboundEmailFailed
+			updates["last_error"] = err.Error()
+		default:
+			log.Printf("Error sending email %s to %s, attempt %d: %v", email.ID, email.To, email.Attempts, err)
+			updates["status"] = OutboundEmailPending
+			updates["last_error"] = err.Error()
+			updates["next_attempt_at"] = time.Now().Add(mailRetryDelay(email.Attempts))
+		}
+		if updates["status"] != OutboundEmailPending {
+			updates["text_body"] = ""
+			updates["html_body"] = ""
+		}
+
+		if err := DB.Model(email).Updates(updates).Error; err != nil {
+			log.Printf("Error recording the delivery of email %s: %v", email.ID, err)
+		}
+	}
+	return nil
+}
+
+// PurgeOutboundEmails deletes the emails sent or given up on before the retention.
+// The bodies of the ones kept are blanked too, in case they were queued before the
+// bodies were blanked on delivery.
+func PurgeOutboundEmails(now time.Time, retention time.Duration) error {
+	err := DB.Model(&OutboundEmail{}).
+		Where("status IN ? AND (text_body <> '' OR html_body <> '')", []OutboundEmailStatus{OutboundEmailSent, OutboundEmailFailed}).
+		Updates(map[string]any{"text_body": "", "html_body": ""}).Error
+	if err != nil {
+		return err
+	}
+
+	result := DB.Where("status IN ? AND updated_at < ?", []OutboundEmailStatus{OutboundEmailSent, OutboundEmailFailed}, now.Add(-retention)).
+		Delete(&OutboundEmail{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Mail queue purge: deleted %d emails", result.RowsAffected)
+	}
+	return nil
+}
+
+// mailRetryDelay returns how long to wait after the given number of failed attempts:
+// one minute, then doubling up to maxMailRetryDelay.
+func mailRetryDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < maxMailRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxMailRetryDelay)
+}
+
+// StartMailQueue delivers queued emails every interval in the background, and
+// forgets the emails that are done with after the retention, unless it is 0.
+func StartMailQueue(interval time.Duration, maxAttempts int, retention time.Duration) {
+	if interval <= 0 {
+		log.Println("Mail queue disabled (MAIL_QUEUE_INTERVAL is not positive), emails won't be sent")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			if err := DeliverQueuedEmails(now, maxAttempts); err != nil {
+				log.Printf("Error delivering queued emails: %v", err)
+			}
+			if retention <= 0 {
+				continue
+			}
+			if err := PurgeOutboundEmails(now, retention); err != nil {
+				log.Printf("Error purging sent emails: %v", err)
+			}
+		}
+	}()
+	log.Printf("Mail queue started, sending emails every %s", interval)
+}
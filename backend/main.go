@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,9 +11,9 @@ import (
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/go-crypt/crypt"
-	"github.com/go-crypt/crypt/algorithm"
 	"github.com/go-crypt/crypt/algorithm/argon2"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
@@ -20,41 +21,106 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 )
 
 // --- Config Struct (Optional but recommended for type safety) ---
 type Config struct {
-	DBHost           string `mapstructure:"DB_HOST"`
-	DBUser           string `mapstructure:"DB_USER"`
-	DBPassword       string `mapstructure:"DB_PASSWORD"`
-	DBName           string `mapstructure:"DB_NAME"`
-	DBPort           string `mapstructure:"DB_PORT"`
-	DBSSLMode        string `mapstructure:"DB_SSLMODE"`
-	DBTimezone       string `mapstructure:"DB_TIMEZONE"`
-	AppEnv           string `mapstructure:"APP_ENV"`
-	Port             string `mapstructure:"PORT"`
-	FrontendURL      string `mapstructure:"FRONTEND_URL"`
-	UserVerification bool   `mapstructure:"FF_USER_VERIFICATION"`
+	DBHost             string        `mapstructure:"DB_HOST"`
+	DBUser             string        `mapstructure:"DB_USER"`
+	DBPassword         string        `mapstructure:"DB_PASSWORD"`
+	DBName             string        `mapstructure:"DB_NAME"`
+	DBPort             string        `mapstructure:"DB_PORT"`
+	DBSSLMode          string        `mapstructure:"DB_SSLMODE"`
+	DBTimezone         string        `mapstructure:"DB_TIMEZONE"`
+	AppEnv             string        `mapstructure:"APP_ENV"`
+	Port               string        `mapstructure:"PORT"`
+	FrontendURL        string        `mapstructure:"FRONTEND_URL"`
+	UserVerification   bool          `mapstructure:"FF_USER_VERIFICATION"`
+	SchedulerInterval  time.Duration `mapstructure:"SCHEDULER_INTERVAL"`
+	TrashRetention     time.Duration `mapstructure:"TRASH_RETENTION"`
+	TrashPurgeInterval time.Duration `mapstructure:"TRASH_PURGE_INTERVAL"`
+
+	// Sessions
+	SessionSigningKeys    []string      `mapstructure:"SESSION_SIGNING_KEYS"`    // Comma separated, newest first, see SessionKeyPairs
+	SessionEncryptionKeys []string      `mapstructure:"SESSION_ENCRYPTION_KEYS"` // Comma separated, paired with the signing keys
+	SessionLifetime       time.Duration `mapstructure:"SESSION_LIFETIME"`
+	SessionPurgeInterval  time.Duration `mapstructure:"SESSION_PURGE_INTERVAL"`
+	PasswordResetTTL      time.Duration `mapstructure:"PASSWORD_RESET_TTL"`      // How long a password reset token can be used
+	PasswordResetInterval time.Duration `mapstructure:"PASSWORD_RESET_INTERVAL"` // Minimum delay between two reset tokens for an account
+	PasswordResetIPMax    int           `mapstructure:"PASSWORD_RESET_IP_MAX"`   // Reset tokens requested from a client address per hour, 0 for no limit
+
+	EmailVerificationTTL       time.Duration `mapstructure:"EMAIL_VERIFICATION_TTL"`       // How long a verification link can be used
+	VerificationResendInterval time.Duration `mapstructure:"VERIFICATION_RESEND_INTERVAL"` // Minimum delay between two verification emails to an account
+	VerificationResendIPMax    int           `mapstructure:"VERIFICATION_RESEND_IP_MAX"`   // Verification emails requested from a client address per hour, 0 for no limit
+
+	// Outbound email
+	PublicURL         string        `mapstructure:"PUBLIC_URL"`  // Base URL of this API, used in links sent by email
+	MailDriver        string        `mapstructure:"MAIL_DRIVER"` // smtp, file or log
+	MailFrom          string        `mapstructure:"MAIL_FROM"`
+	MailDir           string        `mapstructure:"MAIL_DIR"` // Where the file driver writes emails
+	SMTPHost          string        `mapstructure:"SMTP_HOST"`
+	SMTPPort          string        `mapstructure:"SMTP_PORT"`
+	SMTPUsername      string        `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword      string        `mapstructure:"SMTP_PASSWORD"`
+	MailQueueInterval time.Duration `mapstructure:"MAIL_QUEUE_INTERVAL"`
+	MailMaxAttempts   int           `mapstructure:"MAIL_MAX_ATTEMPTS"`
+	MailRetention     time.Duration `mapstructure:"MAIL_RETENTION"` // How long sent and failed emails are kept, 0 for ever
+
+	// Single sign-on with OpenID Connect, disabled while OIDC_ISSUER_URL is empty
+	OIDCIssuerURL     string        `mapstructure:"OIDC_ISSUER_URL"`
+	OIDCClientID      string        `mapstructure:"OIDC_CLIENT_ID"`
+	OIDCClientSecret  string        `mapstructure:"OIDC_CLIENT_SECRET"`
+	OIDCRedirectURL   string        `mapstructure:"OIDC_REDIRECT_URL"` // Defaults to PUBLIC_URL/api/account/oidc/callback
+	OIDCScopes        []string      `mapstructure:"OIDC_SCOPES"`       // Comma separated
+	OIDCAutoProvision bool          `mapstructure:"OIDC_AUTO_PROVISION"`
+	OIDCReauthMaxAge  time.Duration `mapstructure:"OIDC_REAUTH_MAX_AGE"` // How recent a sign in must be to confirm account changes without a password
+
+	// Sign in throttling, see LoginThrottle
+	LoginMaxFailures      int           `mapstructure:"LOGIN_MAX_FAILURES"`    // Failures in a row before an email is locked out
+	LoginIPMaxFailures    int           `mapstructure:"LOGIN_IP_MAX_FAILURES"` // Failures in a row before an address is locked out
+	TrustedProxies        []string      `mapstructure:"TRUSTED_PROXIES"`       // Comma separated reverse proxies allowed to set X-Forwarded-For: behind one, set it or all clients share its address
+	LoginLockout          time.Duration `mapstructure:"LOGIN_LOCKOUT"`         // First lockout, doubling with every further failure
+	LoginMaxLockout       time.Duration `mapstructure:"LOGIN_MAX_LOCKOUT"`
+	LoginFailureWindow    time.Duration `mapstructure:"LOGIN_FAILURE_WINDOW"`    // Failures are forgotten after this long without another one
+	LoginAttemptRetention time.Duration `mapstructure:"LOGIN_ATTEMPT_RETENTION"` // How long failed sign ins are kept for auditing
+
+	// Password policy, see ValidatePassword
+	PasswordMinLength    int    `mapstructure:"PASSWORD_MIN_LENGTH"`
+	PasswordMaxLength    int    `mapstructure:"PASSWORD_MAX_LENGTH"`    // Bounds the cost of hashing, 0 for no limit
+	PasswordMinClasses   int    `mapstructure:"PASSWORD_MIN_CLASSES"`   // Lowercase, uppercase, digits and symbols: how many to mix
+	PasswordBreachedList string `mapstructure:"PASSWORD_BREACHED_LIST"` // File of breached passwords to refuse, see loadPasswordList
+
+	// Argon2 parameters of new digests. Older digests are upgraded on sign in, see NeedsRehash
+	PasswordHashVariant     string `mapstructure:"PASSWORD_HASH_VARIANT"` // argon2id, argon2i or argon2d
+	PasswordHashIterations  int    `mapstructure:"PASSWORD_HASH_ITERATIONS"`
+	PasswordHashMemory      uint32 `mapstructure:"PASSWORD_HASH_MEMORY"` // In KiB
+	PasswordHashParallelism int    `mapstructure:"PASSWORD_HASH_PARALLELISM"`
+	PasswordHashKeyLength   int    `mapstructure:"PASSWORD_HASH_KEY_LENGTH"`
+	PasswordHashSaltLength  int    `mapstructure:"PASSWORD_HASH_SALT_LENGTH"`
 }
 
 var DB *gorm.DB
 var AppConfig Config
-var digest algorithm.Digest
-var hasher *argon2.Hasher
 
 // --- Entities ---
 
-// Form represents the structure of a form
+// Form represents the structure of a form.
+// Questions lists every question of the form in page order; Sections groups them into pages.
 type Form struct {
 	ID            uuid.UUID      `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"` // Use DB generation for UUIDs
 	Title         string         `json:"title" binding:"required"`
 	Description   string         `json:"description"`
 	CreatorUserID string         `json:"creator_user_id" binding:"required"` // Consider if this should be validated
 	Questions     []Question     `json:"questions,omitempty" gorm:"foreignKey:FormID;constraint:OnDelete:CASCADE;"`
-	CreatedAt     time.Time      `json:"created_at"` // Add explicitly
-	UpdatedAt     time.Time      `json:"updated_at"` // Add explicitly
+	Sections      []Section      `json:"sections,omitempty" gorm:"foreignKey:FormID;constraint:OnDelete:CASCADE;"`
+	Version       int            `json:"version" gorm:"not null;default:0"`                         // Latest FormVersion recorded for this form
+	Status        FormStatus     `json:"status" gorm:"type:varchar(16);not null;default:published"` // Forms created before the lifecycle existed stay live
+	OpensAt       *time.Time     `json:"opens_at"`                                                  // Responses are accepted from this time on, if set
+	ClosesAt      *time.Time     `json:"closes_at"`                                                 // Responses are accepted until this time, if set
+	MaxResponses  *int           `json:"max_responses"`                                             // The form closes once it collected this many responses, if set
+	CreatedAt     time.Time      `json:"created_at"`                                                // Add explicitly
+	UpdatedAt     time.Time      `json:"updated_at"`                                                // Add explicitly
 	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
@@ -62,38 +128,65 @@ type Form struct {
 type Question struct {
 	ID         uuid.UUID      `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"` // Use DB generation for UUIDs
 	FormID     uuid.UUID      `json:"-" gorm:"type:uuid"`                                       // Hide from JSON, ensure type match
+	SectionID  *uuid.UUID     `json:"section_id" gorm:"type:uuid;index"`                        // The page the question is on
+	Position   int            `json:"position" gorm:"not null;default:0"`                       // Order of the question within its section, from 0
 	Text       string         `json:"text" binding:"required"`
-	Type       string         `json:"type"` // Consider defining allowed types (e.g., text, rating, choice)
+	Type       string         `json:"type"` // One of the types registered in questionTypes
 	IsRequired bool           `json:"is_required"`
-	ExtraInfo  string         `json:"extra_info"`
-	CreatedAt  time.Time      `json:"created_at"` // Add explicitly
-	UpdatedAt  time.Time      `json:"updated_at"` // Add explicitly
+	Config     QuestionConfig `json:"config" gorm:"type:jsonb;serializer:json"`            // Type-specific settings, checked by ValidateQuestion
+	ShowIf     *Condition     `json:"show_if,omitempty" gorm:"type:jsonb;serializer:json"` // Shown only when this holds, checked by ValidateLogic
+	Jumps      []JumpRule     `json:"jumps,omitempty" gorm:"type:jsonb;serializer:json"`   // Evaluated in order once answered; the first match applies
+	CreatedAt  time.Time      `json:"created_at"`                                          // Add explicitly
+	UpdatedAt  time.Time      `json:"updated_at"`                                          // Add explicitly
 	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// FormDetailsRequest is the body of PUT /forms/:formId, which replaces the details of a form.
+type FormDetailsRequest struct {
+	Title       string `json:"title" binding:"required"`
+	Description string `json:"description"`
+}
+
+// FormPatchRequest is the body of PATCH /forms/:formId. Missing fields are left unchanged.
+type FormPatchRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+}
+
 type QuestionRequest struct {
-	Text       string `json:"text" binding:"required"`
-	Type       string `json:"type"`
-	IsRequired bool   `json:"is_required"`
-	ExtraInfo  string `json:"extra_info"`
+	ID         uuid.UUID      `json:"id"` // Client-supplied, used to match the request against existing questions
+	SectionID  uuid.UUID      `json:"-"`  // Set from the section the question is listed in
+	Text       string         `json:"text" binding:"required"`
+	Type       string         `json:"type"`
+	IsRequired bool           `json:"is_required"`
+	Config     QuestionConfig `json:"config"`
+	ShowIf     *Condition     `json:"show_if"`
+	Jumps      []JumpRule     `json:"jumps"`
 }
 
 // Answer represents a single answer to a question within a response
 type Answer struct {
-	ID         uuid.UUID      `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"` // Use DB generation for UUIDs
-	ResponseID uuid.UUID      `json:"-" gorm:"type:uuid"`                                       // Hide from JSON, ensure type match
-	QuestionID uuid.UUID      `json:"question_id" binding:"required" gorm:"type:uuid"`          // Ensure type match
-	Value      string         `json:"value"`                                                    // Consider max length or validation based on question type
-	CreatedAt  time.Time      `json:"created_at"`                                               // Add explicitly
-	UpdatedAt  time.Time      `json:"updated_at"`                                               // Add explicitly
+	ID         uuid.UUID      `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`                  // Use DB generation for UUIDs
+	ResponseID uuid.UUID      `json:"-" gorm:"type:uuid"`                                                        // Hide from JSON, ensure type match
+	QuestionID uuid.UUID      `json:"question_id" binding:"required" gorm:"type:uuid"`                           // Ensure type match
+	Value      string         `json:"value,omitempty"`                                                           // Answer to single-valued questions, checked by its question type
+	Values     []string       `json:"values,omitempty" gorm:"column:selected_values;type:jsonb;serializer:json"` // Selected choice IDs for multi-valued questions (checkbox, multiselect)
+	CreatedAt  time.Time      `json:"created_at"`                                                                // Add explicitly
+	UpdatedAt  time.Time      `json:"updated_at"`                                                                // Add explicitly
 	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// IsEmpty reports whether the answer carries neither a value nor a selection.
+func (answer *Answer) IsEmpty() bool {
+	return strings.TrimSpace(answer.Value) == "" && len(answer.Values) == 0
+}
+
 // Response represents a submission for a specific form
 type Response struct {
 	ID               uuid.UUID      `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"` // Use DB generation for UUIDs
 	FormID           uuid.UUID      `json:"form_id" gorm:"type:uuid"`                                 // Ensure type match
 	RespondentUserID string         `json:"respondent_user_id" binding:"required"`                    // Consider if this should be validated
+	FormVersion      int            `json:"form_version"`                                             // Version of the form the response was submitted against
 	Answers          []Answer       `json:"answers,omitempty" gorm:"foreignKey:ResponseID;constraint:OnDelete:CASCADE;"`
 	CreatedAt        time.Time      `json:"created_at"` // Add explicitly
 	UpdatedAt        time.Time      `json:"updated_at"` // Add explicitly
@@ -102,10 +195,11 @@ type Response struct {
 
 type User struct {
 	ID        uuid.UUID      `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	Username  string         `json:"username" binding:"required"`
-	Email     string         `json:"email" binding:"required"`
+	Username  string         `json:"username" binding:"required" gorm:"uniqueIndex:idx_users_username_lower,expression:lower(username),where:deleted_at IS NULL"`
+	Email     string         `json:"email" binding:"required" gorm:"uniqueIndex:idx_users_email_lower,expression:lower(email),where:deleted_at IS NULL"`
 	Password  string         `json:"-" binding:"required"`
 	Verified  bool           `json:"verified"`
+	IsAdmin   bool           `json:"is_admin" gorm:"not null;default:false"`
 	CreatedAt time.Time      `json:"created_at"` // Add explicitly
 	UpdatedAt time.Time      `json:"updated_at"` // Add explicitly
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -117,12 +211,22 @@ type Verification struct {
 	User             User      `json:"-" gorm:"foreignKey:UserID"`
 	VerificationCode string    `json:"verification_code"`
 	ExpiresAt        time.Time `json:"expires_at"`
+
+	Purpose   VerificationPurpose `json:"purpose" gorm:"type:varchar(32);not null;default:email"` // Codes issued before purposes existed verify emails
+	TokenHash string              `json:"-" gorm:"index"`                                         // Hash of the token, for tokens that aren't stored in clear
+	NewEmail  string              `json:"-"`                                                      // The address to confirm, for email changes
+	RequestIP string              `json:"-" gorm:"index"`                                         // Where the token was requested from, to rate limit requests
 }
 
 type SignupRequest struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type SigninRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
 }
 
 // --- Load Configuration Function ---
@@ -171,6 +275,57 @@ func LoadConfig() {
 	viper.SetDefault("PORT", "8080")
 	viper.SetDefault("FRONTEND_URL", "frontend") // Default for dev
 	viper.SetDefault("FF_USER_VERIFICATION", true)
+	viper.SetDefault("SCHEDULER_INTERVAL", "1m")   // How often form open/close windows are applied
+	viper.SetDefault("TRASH_RETENTION", "720h")    // How long deleted forms and responses stay in the trash
+	viper.SetDefault("TRASH_PURGE_INTERVAL", "1h") // How often the trash is purged
+
+	viper.SetDefault("SESSION_LIFETIME", "720h")     // How long a sign in lasts
+	viper.SetDefault("SESSION_PURGE_INTERVAL", "1h") // How often expired sessions are deleted
+	viper.SetDefault("PASSWORD_RESET_TTL", "1h")
+	viper.SetDefault("PASSWORD_RESET_INTERVAL", "1m")
+	viper.SetDefault("PASSWORD_RESET_IP_MAX", 10)
+	viper.SetDefault("EMAIL_VERIFICATION_TTL", "24h")
+	viper.SetDefault("VERIFICATION_RESEND_INTERVAL", "1m")
+	viper.SetDefault("VERIFICATION_RESEND_IP_MAX", 10)
+
+	viper.SetDefault("PUBLIC_URL", "http://localhost:8080")
+	viper.SetDefault("MAIL_DRIVER", "log") // Development works without a mail server
+	viper.SetDefault("MAIL_FROM", "GForms <no-reply@localhost>")
+	viper.SetDefault("MAIL_DIR", "mail")
+	viper.SetDefault("SMTP_HOST", "localhost")
+	viper.SetDefault("SMTP_PORT", "1025") // MailHog
+	viper.SetDefault("MAIL_QUEUE_INTERVAL", "10s")
+	viper.SetDefault("MAIL_MAX_ATTEMPTS", 5)
+	viper.SetDefault("MAIL_RETENTION", "720h") // 30 days
+
+	viper.SetDefault("OIDC_ISSUER_URL", "")
+	viper.SetDefault("OIDC_CLIENT_ID", "")
+	viper.SetDefault("OIDC_CLIENT_SECRET", "")
+	viper.SetDefault("OIDC_REDIRECT_URL", "")
+	viper.SetDefault("OIDC_SCOPES", "openid,email,profile")
+	viper.SetDefault("OIDC_AUTO_PROVISION", true) // Create accounts for unknown users of the identity provider
+	viper.SetDefault("OIDC_REAUTH_MAX_AGE", "5m")
+
+	viper.SetDefault("LOGIN_MAX_FAILURES", 5)
+	viper.SetDefault("LOGIN_IP_MAX_FAILURES", 20)
+	viper.SetDefault("TRUSTED_PROXIES", "")
+	viper.SetDefault("LOGIN_LOCKOUT", "1m")
+	viper.SetDefault("LOGIN_MAX_LOCKOUT", "1h")
+	viper.SetDefault("LOGIN_FAILURE_WINDOW", "1h")
+	viper.SetDefault("LOGIN_ATTEMPT_RETENTION", "2160h") // 90 days
+
+	viper.SetDefault("PASSWORD_MIN_LENGTH", 8)
+	viper.SetDefault("PASSWORD_MAX_LENGTH", 128)
+	viper.SetDefault("PASSWORD_MIN_CLASSES", 1)
+	viper.SetDefault("PASSWORD_BREACHED_LIST", "")
+
+	// The RFC 9106 low memory profile
+	viper.SetDefault("PASSWORD_HASH_VARIANT", "argon2id")
+	viper.SetDefault("PASSWORD_HASH_ITERATIONS", 3)
+	viper.SetDefault("PASSWORD_HASH_MEMORY", 64*1024)
+	viper.SetDefault("PASSWORD_HASH_PARALLELISM", 4)
+	viper.SetDefault("PASSWORD_HASH_KEY_LENGTH", 32)
+	viper.SetDefault("PASSWORD_HASH_SALT_LENGTH", 16)
 
 	// 4. Enable reading from Environment Variables
 	viper.AutomaticEnv() // Read in environment variables that match keys
@@ -191,8 +346,8 @@ func LoadConfig() {
 		log.Fatalf("FATAL: DB_PASSWORD must be set in production environment!")
 	}
 
-	if hasher, err = argon2.New(argon2.WithProfileRFC9106LowMemory()); err != nil {
-		panic(err)
+	if err = InitPasswordHasher(); err != nil {
+		log.Fatalf("Invalid password hashing settings: %v", err)
 	}
 
 	log.Println("Configuration loaded successfully.")
@@ -218,7 +373,7 @@ func ConnectDatabase() {
 		AppConfig.DBPort, AppConfig.DBSSLMode, AppConfig.DBTimezone)
 
 	var err error
-	DB, err = gorm.Open(postgres.Open(connectionString), &gorm.Config{})
+	DB, err = gorm.Open(postgres.Open(connectionString), &gorm.Config{TranslateError: true}) // Unique violations become gorm.ErrDuplicatedKey
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -238,6 +393,9 @@ func ConnectDatabase() {
 // AutoMigrateDatabase runs GORM's auto-migration feature.
 func AutoMigrateDatabase() {
 	log.Println("Starting database auto-migration...")
+	if err := CheckDuplicateUsers(); err != nil {
+		log.Fatalf("Failed to migrate users: %v", err)
+	}
 	// Ensure correct order if there are dependencies not handled by GORM automatically
 	err := DB.AutoMigrate(
 		&Form{},
@@ -246,34 +404,75 @@ func AutoMigrateDatabase() {
 		&Answer{},
 		&User{},
 		&Verification{},
+		&FormVersion{},
+		&Section{},
+		&UserSession{},
+		&OutboundEmail{},
+		&APIToken{},
+		&UserIdentity{},
+		&TwoFactor{},
+		&RecoveryCode{},
+		&LoginThrottle{},
+		&LoginAttempt{},
+		&FormTransferOffer{},
 	)
 
 	if err != nil {
 		log.Fatalf("Failed to auto-migrate database: %v", err)
 	}
+
+	if err := MigrateQuestionExtraInfo(); err != nil {
+		log.Fatalf("Failed to migrate question options: %v", err)
+	}
+
+	if err := MigrateQuestionSections(); err != nil {
+		log.Fatalf("Failed to move questions into sections: %v", err)
+	}
+
+	if err := MigrateQuestionPositions(); err != nil {
+		log.Fatalf("Failed to number question positions: %v", err)
+	}
+
+	if err := MigrateMultiValueAnswers(); err != nil {
+		log.Fatalf("Failed to migrate multi-valued answers: %v", err)
+	}
+
+	if err := BackfillFormVersions(); err != nil {
+		log.Fatalf("Failed to record initial form versions: %v", err)
+	}
 	log.Println("Database auto-migration completed")
 }
 
-// CreateForm saves a new form and its questions to the database.
+// CreateForm saves a new form, its sections and its questions to the database.
 func CreateForm(form *Form) error {
-	// UUIDs for Form and Questions are now handled by the DB (default: gen_random_uuid())
-	// GORM automatically handles associations if `form.Questions` is populated.
-	result := DB.Create(form) // Create the form and its nested questions
-	return result.Error
+	// UUIDs for Form, Sections and Questions are handled by the DB (default: gen_random_uuid())
+	sections := form.sectionRequests()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(form).Error; err != nil {
+			return err
+		}
+		// Sections and questions go through the same checks as an update
+		if _, err := SyncSections(tx, form, sections); err != nil {
+			return err
+		}
+		_, err := SnapshotForm(tx, form)
+		return err
+	})
 }
 
 // GetFormByID retrieves a form and its questions by ID.
 func GetFormByID(id string) (*Form, error) {
 	var form Form
-	// Preload fetches associated questions.
+	// Preload fetches associated sections and questions.
 	// Use First to get a single record; returns ErrRecordNotFound if no match.
-	result := DB.Preload("Questions").First(&form, "id = ?", id)
+	result := withSections(DB).First(&form, "id = ?", id)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			return nil, nil // Standard way to indicate "not found"
 		}
 		return nil, result.Error // Other database error
 	}
+	form.collectQuestions()
 	return &form, nil
 }
 
@@ -281,12 +480,15 @@ func GetFormByID(id string) (*Form, error) {
 func GetAllFormsByUser(userId string) ([]Form, error) {
 	var forms []Form
 	// Use Find to get multiple records. Preload questions for each form.
-	result := DB.Preload("Questions").
+	result := withSections(DB).
 		Where("deleted_at IS NULL AND creator_user_id=?", userId).
 		Order("created_at desc").Find(&forms)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	for i := range forms {
+		forms[i].collectQuestions()
+	}
 	// Return empty slice if no forms found, not nil
 	if forms == nil {
 		forms = []Form{}
@@ -309,22 +511,184 @@ func (form *Form) AddQuestion(question *Question) error {
 	return UpdateForm(form)
 }
 
+// ErrInvalidQuestions is returned when a questions update cannot be applied to a form.
+var ErrInvalidQuestions = errors.New("invalid questions")
+
+// Errors returned by form updates.
+var (
+	ErrNotFormOwner = errors.New("only the owner of the form can change it")
+	ErrEmptyTitle   = errors.New("title must not be empty")
+)
+
+// maxQuestions bounds the number of questions of a form, across all its sections.
+const maxQuestions = 50
+
+// SyncQuestions reconciles the questions of a form with the requested list.
+// Questions are matched on their ID: known ones are updated in place, new ones are
+// inserted and the ones missing from the request are soft-retired, so answers that
+// were already collected keep pointing at the question they answered.
+func SyncQuestions(tx *gorm.DB, form *Form, requests []QuestionRequest) ([]Question, error) {
+	var existing []Question
+	// Unscoped so that a question retired by a previous edit can be brought back.
+	if err := tx.Unscoped().Where("form_id = ?", form.ID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	existingByID := make(map[uuid.UUID]Question, len(existing))
+	for _, q := range existing {
+		existingByID[q.ID] = q
+	}
+
+	questions := make([]Question, 0, len(requests))
+	keptIDs := make([]uuid.UUID, 0, len(requests))
+	seen := make(map[uuid.UUID]bool)
+	positions := make(map[uuid.UUID]int) // Next position in each section
+	now := time.Now()
+
+	for i, req := range requests {
+		question := Question{ID: req.ID, FormID: form.ID, CreatedAt: now}
+
+		if req.ID != uuid.Nil {
+			if seen[req.ID] {
+				return nil, fmt.Errorf("%w: duplicate question id %s", ErrInvalidQuestions, req.ID)
+			}
+			seen[req.ID] = true
+
+			if found, ok := existingByID[req.ID]; ok {
+				question = found
+			} else {
+				// The ID must not already be used by a question of another form.
+				var count int64
+				if err := tx.Unscoped().Model(&Question{}).Where("id = ?", req.ID).Count(&count).Error; err != nil {
+					return nil, err
+				}
+				if count > 0 {
+					return nil, fmt.Errorf("%w: question id %s belongs to another form", ErrInvalidQuestions, req.ID)
+				}
+			}
+		}
+
+		sectionID := req.SectionID
+		question.SectionID = &sectionID
+		question.Position = positions[sectionID]
+		positions[sectionID]++
+		question.Text = req.Text
+		question.Type = req.Type
+		question.IsRequired = req.IsRequired
+		question.Config = req.Config
+		question.ShowIf = req.ShowIf
+		question.Jumps = req.Jumps
+		question.UpdatedAt = now
+
+		if err := ValidateQuestion(&question); err != nil {
+			return nil, fmt.Errorf("%w: question %d: %v", ErrInvalidQuestions, i+1, err)
+		}
+		question.DeletedAt = gorm.DeletedAt{} // Restores a previously retired question
+
+		if _, ok := existingByID[question.ID]; ok {
+			if err := tx.Unscoped().Save(&question).Error; err != nil {
+				return nil, err
+			}
+		} else {
+			if err := tx.Create(&question).Error; err != nil {
+				return nil, err
+			}
+		}
+
+		keptIDs = append(keptIDs, question.ID)
+		questions = append(questions, question)
+	}
+
+	// Conditions and jumps are checked once every question has its ID.
+	if err := ValidateLogic(questions); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuestions, err)
+	}
+
+	// Retire the questions that are no longer part of the form.
+	retire := tx.Where("form_id = ?", form.ID)
+	if len(keptIDs) > 0 {
+		retire = retire.Where("id NOT IN ?", keptIDs)
+	}
+	if err := retire.Delete(&Question{}).Error; err != nil {
+		return nil, err
+	}
+
+	return questions, nil
+}
+
 // UpdateForm updates an existing form (and potentially its questions via associations).
 func UpdateForm(form *Form) error {
-	result := DB.Save(form)
-	return result.Error
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(form).Error; err != nil {
+			return err
+		}
+		_, err := SnapshotForm(tx, form)
+		return err
+	})
 }
 
-// DeleteForm deletes a form by ID. Associated questions/responses might be deleted by CASCADE constraint.
-func DeleteForm(id string) error {
-	// DB.Delete performs a soft delete if gorm.Model is used (sets deleted_at).
-	// Use DB.Unscoped().Delete(...) for a hard delete.
-	result := DB.Delete(&Form{}, "id = ?", id)
-	// Check RowsAffected if you need to know if a record was actually deleted.
-	if result.Error == nil && result.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound // Return not found if ID didn't exist
+// UpdateFormDetails changes the title and description of a form owned by the given user.
+// Like question edits, it is only allowed on drafts and records a new version.
+func UpdateFormDetails(id string, ownerID uuid.UUID, patch FormPatchRequest) (*Form, error) {
+	var form Form
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := withSections(tx.Clauses(clause.Locking{Strength: "UPDATE"})).First(&form, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if form.CreatorUserID != ownerID.String() {
+			return ErrNotFormOwner
+		}
+		if !form.IsEditable() {
+			return ErrFormNotEditable
+		}
+
+		if patch.Title != nil {
+			form.Title = strings.TrimSpace(*patch.Title)
+		}
+		if patch.Description != nil {
+			form.Description = *patch.Description
+		}
+		if form.Title == "" {
+			return ErrEmptyTitle
+		}
+
+		if err := tx.Model(&form).Updates(map[string]any{"title": form.Title, "description": form.Description}).Error; err != nil {
+			return err
+		}
+		_, err := SnapshotForm(tx, &form)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return result.Error
+
+	form.collectQuestions()
+	return &form, nil
+}
+
+// DeleteForm soft-deletes a form by ID, together with its responses and their answers.
+// They all share the same deleted_at, so the deletion can be told apart from responses
+// that were deleted on their own. Questions and sections are left as they are.
+func DeleteForm(id string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// Update rather than Delete, to set the same timestamp everywhere.
+		// Only rows that are not already deleted are touched.
+		result := tx.Model(&Form{}).Where("id = ?", id).Update("deleted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound // Return not found if ID didn't exist
+		}
+
+		responseIDs := tx.Model(&Response{}).Select("id").Where("form_id = ?", id)
+		if err := tx.Model(&Answer{}).Where("response_id IN (?)", responseIDs).Update("deleted_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Response{}).Where("form_id = ?", id).Update("deleted_at", now).Error
+	})
 }
 
 // CreateResponse saves a new response and its answers to the database.
@@ -383,13 +747,21 @@ func UpdateResponse(response *Response) error {
 	return result.Error
 }
 
-// DeleteResponse deletes a response by ID. Associated answers might be deleted by CASCADE.
+// DeleteResponse soft-deletes a response and its answers. They share the same deleted_at,
+// so restoring the response brings back exactly the answers deleted with it.
 func DeleteResponse(id string) error {
-	result := DB.Delete(&Response{}, "id = ?", id)
-	if result.Error == nil && result.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound
-	}
-	return result.Error
+	return DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		result := tx.Model(&Response{}).Where("id = ?", id).Update("deleted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Model(&Answer{}).Where("response_id = ?", id).Update("deleted_at", now).Error
+	})
 }
 
 // Note: CRUD functions for individual Questions/Answers might be less common
@@ -401,13 +773,7 @@ func DeleteResponse(id string) error {
 // createFormHandler handles POST /forms requests.
 func createFormHandler(c *gin.Context) {
 	var newForm Form
-
-	session := sessions.Default(c)
-
-	if session.Get("username") == nil {
-		http.Redirect(c.Writer, c.Request, "/api/account/login", http.StatusSeeOther)
-		return
-	}
+	user := currentUser(c) // Routes with requireUser always have a user
 
 	// Bind JSON payload to the Form struct
 	if err := c.ShouldBindJSON(&newForm); err != nil {
@@ -416,27 +782,16 @@ func createFormHandler(c *gin.Context) {
 		return
 	}
 
-	// Optional: Set default question type if not provided in the request
-	for i := range newForm.Questions {
-		if newForm.Questions[i].Type == "" {
-			newForm.Questions[i].Type = "text" // Set your desired default type
-		}
-		// DB will generate Question IDs
-	}
-
-	var userFound User
-	DB.Find(&userFound, "username = ?", session.Get("username"))
-
-	if userFound.ID == uuid.Nil {
-		log.Printf("Error: User not found for session username %s", session.Get("username"))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
-		return
-	}
-
-	newForm.CreatorUserID = userFound.ID.String()
+	newForm.CreatorUserID = user.ID.String()
+	newForm.Status = FormStatusDraft // New forms are built as drafts and published explicitly
 
 	// Attempt to create the form in the database
+	// Question types, configurations and logic are checked while saving
 	if err := CreateForm(&newForm); err != nil {
+		if errors.Is(err, ErrInvalidQuestions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		log.Printf("Error creating form in DB: %v", err)
 		// Provide a generic error message to the client
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save form"})
@@ -448,84 +803,60 @@ func createFormHandler(c *gin.Context) {
 	c.JSON(http.StatusCreated, newForm)
 }
 
+// setQuestionsHandler handles PUT /forms/:formId/questions requests.
 func setQuestionsHandler(c *gin.Context) {
 	formIDStr := c.Param("formId")
-	var questionsRequest []QuestionRequest
-	var questions []Question
+	foundForm := currentForm(c) // Loaded and authorized by authorizeForm
 
-	foundForm, err := GetFormByID(formIDStr)
-
-	if err != nil {
-		log.Printf("Error checking form %s existence for adding question: %v", formIDStr, err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+	if !foundForm.IsEditable() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only draft forms can be edited, unpublish the form first"})
 		return
 	}
 
-	if err := c.ShouldBindJSON(&questionsRequest); err != nil {
+	// Either a flat list of questions or {"sections": [...]}
+	sectionsRequest, err := bindQuestionsUpdate(c, foundForm)
+	if err != nil {
 		log.Printf("Error binding JSON questions: %v", err)
 		c.JSON(http.StatusBadRequest, "Invalid or incomplete JSON request: "+err.Error())
 		return
 	}
 
-	if len(questionsRequest) > 50 {
-		log.Printf("Error too many questions (max length is 50)")
-		c.JSON(http.StatusBadRequest, "Too many questions (max length is 50)")
-		return
-	}
-
 	tx := DB.Begin()
-	if err := tx.Where("form_id = ?", formIDStr).Delete(&Question{}).Error; err != nil {
+	_, err = SyncSections(tx, foundForm, sectionsRequest)
+	if err != nil {
 		tx.Rollback()
+		if errors.Is(err, ErrInvalidQuestions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error saving questions in DB: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save questions"})
 		return
 	}
 
-	for i := range questionsRequest {
-		var question Question
-		question.Text = questionsRequest[i].Text
-		question.Type = questionsRequest[i].Type
-		question.IsRequired = questionsRequest[i].IsRequired
-		question.ExtraInfo = questionsRequest[i].ExtraInfo
-		question.FormID = foundForm.ID
-		question.CreatedAt = time.Now()
-		question.UpdatedAt = time.Now()
-		questions = append(questions, question)
+	if _, err := SnapshotForm(tx, foundForm); err != nil {
+		tx.Rollback()
+		log.Printf("Error recording version for form %s: %v", formIDStr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save questions"})
+		return
 	}
 
-	if err := tx.Create(&questions).Error; err != nil {
-		log.Printf("Error creating questions in DB: %v", err)
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error committing questions for form %s: %v", formIDStr, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save questions"})
-		tx.Rollback()
 		return
 	}
 
-	foundForm.Questions = questions // Update the form with the new questions
-
-	tx.Commit()
-
+	// SyncSections updated the form's sections and questions
 	c.JSON(http.StatusOK, foundForm)
 
 }
 
 // listFormsHandler handles GET /forms requests.
 func listFormsHandler(c *gin.Context) {
+	user := currentUser(c)
 
-	session := sessions.Default(c)
-
-	if session.Get("username") == nil {
-		http.Redirect(c.Writer, c.Request, "/api/account/login", http.StatusSeeOther)
-		return
-	}
-
-	var userFound User
-	DB.Find(&userFound, "username = ?", session.Get("username"))
-
-	if userFound.ID == uuid.Nil {
-		log.Printf("Error: User not found for session username %s", session.Get("username"))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
-		return
-	}
-
-	allForms, err := GetAllFormsByUser(userFound.ID.String())
+	allForms, err := GetAllFormsByUser(user.ID.String())
 	if err != nil {
 		log.Printf("Error retrieving forms: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving forms"})
@@ -538,28 +869,68 @@ func listFormsHandler(c *gin.Context) {
 
 // getFormHandler handles GET /forms/:formId requests.
 func getFormHandler(c *gin.Context) {
+	// The form was loaded by authorizeForm, which also hides drafts from anyone but their owner
+	c.JSON(http.StatusOK, currentForm(c))
+}
+
+// updateFormHandler handles PUT and PATCH /forms/:formId requests.
+func updateFormHandler(c *gin.Context) {
 	formID := c.Param("formId")
+	user := currentUser(c)
 
-	// Validate if formID looks like a UUID (optional but good practice)
-	// if _, err := uuid.Parse(formID); err != nil {
-	// 	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form ID format"})
-	//  return
-	// }
+	var patch FormPatchRequest
+	if c.Request.Method == http.MethodPut {
+		var details FormDetailsRequest
+		if err := c.ShouldBindJSON(&details); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or incomplete JSON request: " + err.Error()})
+			return
+		}
+		patch = FormPatchRequest{Title: &details.Title, Description: &details.Description}
+	} else if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or incomplete JSON request: " + err.Error()})
+		return
+	}
 
-	form, err := GetFormByID(formID)
+	form, err := UpdateFormDetails(formID, user.ID, patch)
 	if err != nil {
-		// Log the actual database error
-		log.Printf("Error retrieving form %s: %v", formID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving form"})
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+		case errors.Is(err, ErrNotFormOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrFormNotEditable):
+			c.JSON(http.StatusConflict, gin.H{"error": "Only draft forms can be edited, unpublish the form first"})
+		case errors.Is(err, ErrEmptyTitle):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error updating form %s: %v", formID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update form"})
+		}
 		return
 	}
-	// GetFormByID returns nil, nil if not found
-	if form == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+
+	log.Printf("Form updated: ID=%s, Title=%s", form.ID, form.Title)
+	c.JSON(http.StatusOK, form)
+}
+
+// deleteFormHandler handles DELETE /forms/:formId requests.
+// The form and its responses are soft-deleted.
+func deleteFormHandler(c *gin.Context) {
+	formID := c.Param("formId")
+	user := currentUser(c)
+
+	if err := DeleteForm(formID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+			return
+		}
+		log.Printf("Error deleting form %s: %v", formID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete form"})
 		return
 	}
 
-	c.JSON(http.StatusOK, form)
+	log.Printf("Form deleted: ID=%s by UserID=%s", formID, user.ID)
+	c.Status(http.StatusNoContent)
 }
 
 // submitResponseHandler handles POST /forms/:formId/responses requests.
@@ -576,15 +947,10 @@ func submitResponseHandler(c *gin.Context) {
 		return // Stop further execution in the handler
 	}
 
-	// 1. Check if the target form exists
-	targetForm, err := GetFormByID(formIDStr)
-	if err != nil {
-		log.Printf("Error checking form %s existence for submission: %v", formIDStr, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving form"})
-		return
-	}
-	if targetForm == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+	// 1. The target form was loaded by authorizeForm; check that it accepts responses
+	targetForm := currentForm(c)
+	if err := targetForm.CheckAcceptingResponses(time.Now()); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -596,49 +962,27 @@ func submitResponseHandler(c *gin.Context) {
 		return
 	}
 
-	// 3. Validate the response against the form's questions
-	questionMap := make(map[uuid.UUID]Question) // Map question ID to Question struct for easy lookup
-	for _, q := range targetForm.Questions {
-		questionMap[q.ID] = q
-	}
-
-	answeredQuestions := make(map[uuid.UUID]bool) // Track answered question IDs
-	for i := range newResponse.Answers {
-		ans := &newResponse.Answers[i] // Get pointer to modify if needed (e.g., setting ResponseID)
-
-		// Check if the QuestionID submitted actually exists in the target form
-		q, exists := questionMap[ans.QuestionID]
-		if !exists {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid question ID in response: " + ans.QuestionID.String()})
-			return
-		}
-
-		// Check if a required question was left empty
-		// Note: Allows empty string for non-required questions
-		if ans.Value == "" && q.IsRequired {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing answer for required question: " + q.Text})
-			return
-		}
-		answeredQuestions[ans.QuestionID] = true
-		// DB will generate Answer IDs
-		// The ResponseID will be set automatically by GORM when creating the Response with nested Answers
-	}
-
-	// Check if all required questions from the form were answered
-	for _, q := range targetForm.Questions {
-		if q.IsRequired {
-			if _, answered := answeredQuestions[q.ID]; !answered {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Missing answer for required question: " + q.Text})
-				return
-			}
-		}
+	// 3. Validate the response against the questions the respondent was shown;
+	// answers to questions hidden by conditions or skipped by jumps are dropped
+	shownQuestions, keptAnswers := ApplyLogic(targetForm.Questions, newResponse.Answers)
+	newResponse.Answers = keptAnswers
+	if fieldErrors := ValidateAnswers(shownQuestions, newResponse.Answers); len(fieldErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Some answers are not valid", "fields": fieldErrors})
+		return
 	}
+	// DB will generate Answer IDs
+	// The ResponseID will be set automatically by GORM when creating the Response with nested Answers
 
 	// 4. Prepare and save the response
-	newResponse.FormID = formID // Associate response with the form
+	newResponse.FormID = formID                  // Associate response with the form
+	newResponse.FormVersion = targetForm.Version // Pin the response to the version it was validated against
 	// ID and SubmittedAt (CreatedAt) will be handled by DB/GORM
 
-	if err := CreateResponse(&newResponse); err != nil {
+	if err := SubmitResponse(&newResponse); err != nil {
+		if errors.Is(err, ErrNotAcceptingResponses) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		log.Printf("Error creating response in DB for form %s: %v", formID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save response"})
 		return
@@ -686,22 +1030,35 @@ func signupHandler(c *gin.Context) {
 		return
 	}
 
-	var count int64 = 0
-	DB.Find(&User{}, "email = ?", signupRequest.Email).Count(&count)
-
-	if count > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already exists"})
+	signupRequest.Username = strings.TrimSpace(signupRequest.Username)
+	signupRequest.Email = strings.TrimSpace(signupRequest.Email)
+	if err := signupRequest.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	DB.Find(&User{}, "username = ?", signupRequest.Username).Count(&count)
-	if count > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username already exists"})
+	// Checked first for a precise message; the unique indexes settle races
+	emailExists, err := emailTaken(DB, signupRequest.Email, uuid.Nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if emailExists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+		return
+	}
+	usernameExists, err := usernameTaken(DB, signupRequest.Username, uuid.Nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if usernameExists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
 		return
 	}
 
-	var err error
-	if digest, err = hasher.Hash(signupRequest.Password); err != nil {
+	passwordDigest, err := HashPassword(signupRequest.Password)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -709,121 +1066,91 @@ func signupHandler(c *gin.Context) {
 	var newUser User
 	newUser.Username = signupRequest.Username
 	newUser.Email = signupRequest.Email
-	newUser.Password = digest.Encode()
+	newUser.Password = passwordDigest
 
 	if ret := DB.Create(&newUser); ret.Error != nil {
+		if errors.Is(ret.Error, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email or username already exists"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": ret.Error})
 		return
 	}
 
-	var newUserVerification Verification
-	newUserVerification.UserID = newUser.ID
-	newUserVerification.VerificationCode = uuid.New().String()
-	newUserVerification.ExpiresAt = time.Now().Add(150 * time.Hour) // 24 hours
-
-	if ret := DB.Create(&newUserVerification); ret.Error != nil {
-		log.Println("Error creating verification record:", ret.Error)
+	if err := IssueEmailVerification(DB, &newUser, c.ClientIP()); err != nil {
+		log.Printf("Error issuing verification for %s: %v", newUser.Username, err)
 	}
 
-	log.Printf("Account %s created and can be verified at http://%s/api/account/verify?verificationCode=%s",
-		newUser.Username,
-		c.Request.Host,
-		newUserVerification.VerificationCode)
-
 	c.JSON(http.StatusCreated, newUser)
 }
 
 func signinHandler(c *gin.Context) {
-	var loginRequest SignupRequest
+	var loginRequest SigninRequest
 
 	if err := c.ShouldBindJSON(&loginRequest); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if !checkLoginThrottle(c, loginRequest.Email) {
+		return
+	}
+
 	var user User
-	DB.Find(&user, "email = ?", loginRequest.Email)
+	DB.Find(&user, "lower(email) = lower(?)", strings.TrimSpace(loginRequest.Email))
 
 	if user.ID == uuid.Nil {
+		recordLoginFailure(c, loginRequest.Email, nil, LoginFailureUnknownEmail)
 		c.JSON(http.StatusForbidden, gin.H{"error": "Wrong credentials"})
 		return
 	}
 
-	if AppConfig.UserVerification {
-		if user.Verified == false {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Account not verified"})
-			return
-		}
-	}
-
-	var valid bool
-	var err error
-	if valid, err = crypt.CheckPassword(loginRequest.Password, user.Password); err != nil || !valid {
+	if !checkUserPassword(&user, loginRequest.Password) {
+		recordLoginFailure(c, loginRequest.Email, &user, LoginFailureWrongPassword)
 		c.JSON(http.StatusForbidden, gin.H{"error": "Wrong credentials"})
 		return
 	}
-
-	session := sessions.Default(c)
-	session.Set("username", user.Username)
-	session.Save()
-
-	c.JSON(http.StatusOK, gin.H{"username": user.Username, "email": user.Email, "message": "Signed in successfully"})
-}
-
-func logoutHandler(c *gin.Context) {
-	session := sessions.Default(c)
-	session.Clear()
-	session.Save()
-}
-
-func verifyHandler(c *gin.Context) {
-	verificationParam := c.Query("verificationCode")
-
-	log.Printf("Received verification code: %s", verificationParam)
-
-	var verification Verification
-	DB.Find(&verification, "verification_code = ?", verificationParam)
-
-	log.Printf("Verification record found: %+v", verification)
-
-	if verification.ID == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid verification code"})
+	// Checked after the password, so only its owner learns that the account exists
+	if AppConfig.UserVerification && !user.Verified {
+		recordLoginFailure(c, loginRequest.Email, &user, LoginFailureUnverified)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account not verified"})
 		return
 	}
 
-	if time.Now().After(verification.ExpiresAt) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification code has expired"})
+	twoFactorRequired, err := signIn(c, &user)
+	if err != nil {
+		log.Printf("Error saving session for user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign in"})
 		return
 	}
-
-	// Fetch the user from the database to ensure we're working with the latest data
-	var user User
-	if err := DB.First(&user, verification.UserID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user data"})
+	if twoFactorRequired {
+		// Partial session: POST /api/account/signin/2fa completes the sign in
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "message": "Enter the code of your authenticator app"})
 		return
 	}
+	if err := ResetLoginFailures(user.Email); err != nil {
+		log.Printf("Error resetting failed sign ins of user %s: %v", user.ID, err)
+	}
 
-	user.Verified = true
-	DB.Save(&user)
-	DB.Delete(&verification) // Delete the verification record after successful verification
+	c.JSON(http.StatusOK, gin.H{"username": user.Username, "email": user.Email, "message": "Signed in successfully"})
 }
 
-func whoamiHandler(c *gin.Context) {
+// logoutHandler handles POST /api/account/signout requests. It ends the current session.
+func logoutHandler(c *gin.Context) {
 	session := sessions.Default(c)
-	username := session.Get("username")
-
-	if username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not signed in"})
+	session.Clear()
+	session.Options(sessions.Options{Path: "/", MaxAge: -1})
+	if err := session.Save(); err != nil {
+		log.Printf("Error ending session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign out"})
 		return
 	}
 
-	var user User
-	DB.Find(&user, "username = ?", username)
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out successfully"})
+}
 
-	if user.ID == uuid.Nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
+func whoamiHandler(c *gin.Context) {
+	user := currentUser(c)
 	c.JSON(http.StatusOK, gin.H{"username": user.Username, "email": user.Email, "message": "User details retrieved successfully"})
 }
 
@@ -839,6 +1166,23 @@ func main() {
 	// Run migrations after connection is established
 	AutoMigrateDatabase()
 
+	// Load the passwords refused by the password policy
+	InitPasswordPolicy()
+
+	// Open and close forms according to their schedule
+	StartFormScheduler(AppConfig.SchedulerInterval)
+
+	// Delete for good what stayed in the trash longer than the retention period
+	StartTrashPurger(AppConfig.TrashRetention, AppConfig.TrashPurgeInterval)
+
+	// Forget sessions once they expired
+	StartSessionPurger(AppConfig.SessionPurgeInterval)
+	StartLoginAttemptPurger(AppConfig.SessionPurgeInterval, AppConfig.LoginAttemptRetention)
+
+	// Send the emails queued by the handlers
+	InitMailer()
+	StartMailQueue(AppConfig.MailQueueInterval, AppConfig.MailMaxAttempts, AppConfig.MailRetention)
+
 	// Set Gin mode based on environment (production/debug)
 	appEnv := os.Getenv("APP_ENV")
 	if appEnv == "production" || appEnv == "prod" {
@@ -849,9 +1193,13 @@ func main() {
 
 	// Initialize Gin router
 	router := gin.Default() // Includes logger and recovery middleware
+	if err := router.SetTrustedProxies(AppConfig.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	router.Use(resolveClientIP) // Before the sessions, which record the address
 
-	store := cookie.NewStore([]byte("secret"))
-	router.Use(sessions.Sessions("gform_session", store))
+	store := NewSessionStore()
+	router.Use(sessions.Sessions(sessionCookieName, store))
 
 	// --- CORS Configuration ---
 	config := cors.DefaultConfig()
@@ -876,32 +1224,108 @@ func main() {
 	}
 
 	router.Use(cors.New(config)) // Apply CORS middleware
+	router.Use(authenticate)     // Resolve the signed in user, if any, for every route
 
 	// --- API Routes ---
 	// Group routes under /forms
+	// Scopes an API token needs for each route; requests made with a session have them all
+	formsRead, formsWrite := requireScope(ScopeFormsRead), requireScope(ScopeFormsWrite)
+	responsesRead, responsesWrite := requireScope(ScopeResponsesRead), requireScope(ScopeResponsesWrite)
+
 	formRoutes := router.Group("/forms")
 	{
-		formRoutes.POST("", createFormHandler)                    // POST /forms
-		formRoutes.PUT("/:formId/questions", setQuestionsHandler) // PUT /forms/{formId}/questions")
-		formRoutes.GET("", listFormsHandler)                      // GET /forms
-		formRoutes.GET("/:formId", getFormHandler)                // GET /forms/{formId}
-		// Add PUT /forms/{formId} and DELETE /forms/{formId} handlers if needed
-
-		// Group response routes under /forms/{formId}/responses
-		responseRoutes := formRoutes.Group("/:formId/responses")
+		formRoutes.POST("", requireUser, formsWrite, createFormHandler) // POST /forms
+		formRoutes.GET("", requireUser, formsRead, listFormsHandler)    // GET /forms
+
+		// Routes open to respondents: anyone can see and answer a form that is not a draft
+		respondRoutes := formRoutes.Group("/:formId", authorizeForm(FormActionRespond))
+		{
+			respondRoutes.GET("", formsRead, getFormHandler)                            // GET /forms/{formId}
+			respondRoutes.POST("/responses", submitResponseHandler)                     // POST /forms/{formId}/responses
+			respondRoutes.POST("/sections/:sectionId/validate", validateSectionHandler) // POST /forms/{formId}/sections/{sectionId}/validate
+		}
+
+		// Routes reserved to the owner of the form
+		manageRoutes := formRoutes.Group("/:formId", requireUser, authorizeForm(FormActionManage))
 		{
-			responseRoutes.POST("", submitResponseHandler)  // POST /forms/{formId}/responses
-			responseRoutes.GET("", getFormResponsesHandler) // GET /forms/{formId}/responses
-			// Add GET /forms/{formId}/responses/{responseId}, PUT, DELETE handlers if needed
+			manageRoutes.PUT("", formsWrite, updateFormHandler)                                     // PUT /forms/{formId}
+			manageRoutes.PATCH("", formsWrite, updateFormHandler)                                   // PATCH /forms/{formId}
+			manageRoutes.DELETE("", formsWrite, deleteFormHandler)                                  // DELETE /forms/{formId}
+			manageRoutes.PUT("/questions", formsWrite, setQuestionsHandler)                         // PUT /forms/{formId}/questions
+			manageRoutes.PATCH("/questions/:questionId/move", formsWrite, moveQuestionHandler)      // PATCH /forms/{formId}/questions/{questionId}/move
+			manageRoutes.PATCH("/sections/:sectionId/move", formsWrite, moveSectionHandler)         // PATCH /forms/{formId}/sections/{sectionId}/move
+			manageRoutes.GET("/versions", formsRead, listFormVersionsHandler)                       // GET /forms/{formId}/versions
+			manageRoutes.GET("/versions/diff", formsRead, diffFormVersionsHandler)                  // GET /forms/{formId}/versions/diff?from=N&to=M
+			manageRoutes.GET("/versions/:version", formsRead, getFormVersionHandler)                // GET /forms/{formId}/versions/{version}
+			manageRoutes.POST("/publish", formsWrite, transitionFormHandler(PublishTransition))     // POST /forms/{formId}/publish
+			manageRoutes.POST("/unpublish", formsWrite, transitionFormHandler(UnpublishTransition)) // POST /forms/{formId}/unpublish
+			manageRoutes.POST("/close", formsWrite, transitionFormHandler(CloseTransition))         // POST /forms/{formId}/close
+			manageRoutes.POST("/reopen", formsWrite, transitionFormHandler(ReopenTransition))       // POST /forms/{formId}/reopen
+			manageRoutes.PUT("/schedule", formsWrite, setScheduleHandler)                           // PUT /forms/{formId}/schedule
+			manageRoutes.GET("/responses", responsesRead, getFormResponsesHandler)                  // GET /forms/{formId}/responses
+			manageRoutes.GET("/responses/export", responsesRead, exportFormResponsesHandler)        // GET /forms/{formId}/responses/export
+			manageRoutes.DELETE("/responses/:responseId", responsesWrite, deleteResponseHandler)    // DELETE /forms/{formId}/responses/{responseId}
+			// Add GET /forms/{formId}/responses/{responseId}, PUT handlers if needed
+		}
+
+		trashRoutes := router.Group("/trash", requireUser)
+		{
+			trashRoutes.GET("/forms", formsRead, listTrashedFormsHandler)                                                                    // GET /trash/forms
+			trashRoutes.POST("/forms/:formId/restore", formsWrite, trashActionHandler("formId", "restore", RestoreForm))                     // POST /trash/forms/{formId}/restore
+			trashRoutes.DELETE("/forms/:formId", formsWrite, trashActionHandler("formId", "purge", PurgeForm))                               // DELETE /trash/forms/{formId}
+			trashRoutes.GET("/responses", responsesRead, listTrashedResponsesHandler)                                                        // GET /trash/responses
+			trashRoutes.POST("/responses/:responseId/restore", responsesWrite, trashActionHandler("responseId", "restore", RestoreResponse)) // POST /trash/responses/{responseId}/restore
+			trashRoutes.DELETE("/responses/:responseId", responsesWrite, trashActionHandler("responseId", "purge", PurgeResponse))           // DELETE /trash/responses/{responseId}
 		}
 
 		authnRoutes := router.Group("/api/account")
 		{
 			authnRoutes.POST("/signup", signupHandler)
 			authnRoutes.POST("/signin", signinHandler)
+			authnRoutes.POST("/signin/2fa", signinTwoFactorHandler) // POST /api/account/signin/2fa, after a sign in that answered two_factor_required
 			authnRoutes.POST("/signout", logoutHandler)
 			authnRoutes.POST("/verify", verifyHandler)
-			authnRoutes.GET("/whoami", whoamiHandler)
+			authnRoutes.GET("/verify", verifyLinkHandler)                 // GET /api/account/verify?verificationCode=..., the link sent by email
+			authnRoutes.POST("/verify/resend", resendVerificationHandler) // POST /api/account/verify/resend
+			authnRoutes.GET("/email/confirm", confirmEmailChangeHandler)  // GET /api/account/email/confirm?token=..., the link sent to a new address
+			authnRoutes.GET("/whoami", requireUser, whoamiHandler)
+			authnRoutes.POST("/password/forgot", passwordForgotHandler)                                   // POST /api/account/password/forgot
+			authnRoutes.POST("/password/reset", passwordResetHandler)                                     // POST /api/account/password/reset
+			authnRoutes.GET("/sessions", requireUser, requireSession, listSessionsHandler)                // GET /api/account/sessions
+			authnRoutes.DELETE("/sessions", requireUser, requireSession, revokeSessionsHandler)           // DELETE /api/account/sessions
+			authnRoutes.DELETE("/sessions/:sessionId", requireUser, requireSession, revokeSessionHandler) // DELETE /api/account/sessions/{sessionId}
+			authnRoutes.GET("/tokens", requireUser, requireSession, listAPITokensHandler)                 // GET /api/account/tokens
+			authnRoutes.POST("/tokens", requireUser, requireSession, createAPITokenHandler)               // POST /api/account/tokens
+			authnRoutes.DELETE("/tokens/:tokenId", requireUser, requireSession, revokeAPITokenHandler)    // DELETE /api/account/tokens/{tokenId}
+			authnRoutes.GET("/oidc", oidcInfoHandler)                                                     // GET /api/account/oidc
+			authnRoutes.GET("/oidc/login", oidcLoginHandler)                                              // GET /api/account/oidc/login?redirect=/path
+			authnRoutes.GET("/oidc/callback", oidcCallbackHandler)                                        // GET /api/account/oidc/callback, where the identity provider redirects
+
+			authnRoutes.GET("/2fa", requireUser, requireSession, twoFactorStatusHandler)                         // GET /api/account/2fa
+			authnRoutes.POST("/2fa/enroll", requireUser, requireSession, enrollTwoFactorHandler)                 // POST /api/account/2fa/enroll
+			authnRoutes.POST("/2fa/confirm", requireUser, requireSession, confirmTwoFactorHandler)               // POST /api/account/2fa/confirm
+			authnRoutes.POST("/2fa/disable", requireUser, requireSession, disableTwoFactorHandler)               // POST /api/account/2fa/disable
+			authnRoutes.POST("/2fa/recovery-codes", requireUser, requireSession, regenerateRecoveryCodesHandler) // POST /api/account/2fa/recovery-codes
+		}
+
+		// Account self-service, reserved to sessions like the other routes that manage the account
+		selfRoutes := router.Group("/api/account", requireUser, requireSession)
+		{
+			selfRoutes.PUT("/username", changeUsernameHandler) // PUT /api/account/username
+			selfRoutes.PUT("/password", changePasswordHandler) // PUT /api/account/password
+			selfRoutes.PUT("/email", changeEmailHandler)       // PUT /api/account/email
+			selfRoutes.DELETE("", deleteAccountHandler)        // DELETE /api/account
+
+			selfRoutes.GET("/transfer-offers", listFormTransferOffersHandler)                   // GET /api/account/transfer-offers
+			selfRoutes.POST("/transfer-offers", createFormTransferOfferHandler)                 // POST /api/account/transfer-offers
+			selfRoutes.POST("/transfer-offers/:offerId/accept", acceptFormTransferOfferHandler) // POST /api/account/transfer-offers/:offerId/accept
+			selfRoutes.DELETE("/transfer-offers/:offerId", deleteFormTransferOfferHandler)      // DELETE /api/account/transfer-offers/:offerId
+		}
+
+		adminRoutes := router.Group("/api/admin", requireUser, requireSession, requireAdmin)
+		{
+			adminRoutes.POST("/users/:userId/unlock", unlockUserHandler)               // POST /api/admin/users/{userId}/unlock
+			adminRoutes.GET("/users/:userId/login-attempts", listLoginAttemptsHandler) // GET /api/admin/users/{userId}/login-attempts
 		}
 
 	}
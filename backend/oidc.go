@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// Session values holding a login in progress, between the redirect to the identity
+// provider and the callback.
+const (
+	sessionOIDCStateKey    = "oidc_state"
+	sessionOIDCNonceKey    = "oidc_nonce"
+	sessionOIDCVerifierKey = "oidc_verifier"
+	sessionOIDCRedirectKey = "oidc_redirect"
+	sessionOIDCReauthKey   = "oidc_reauth"
+)
+
+// sessionSSOAuthTimeKey holds when the user of a session last authenticated with the
+// identity provider, as a Unix time. See recentSingleSignOn.
+const sessionSSOAuthTimeKey = "sso_auth_time"
+
+// Errors returned when an identity can't be turned into a user.
+var (
+	ErrEmailNotVerified   = errors.New("the identity provider has not verified the email")
+	ErrProvisioningClosed = errors.New("no account uses this email and auto-provisioning is disabled")
+)
+
+// --- Entities ---
+
+// UserIdentity links a user to an account of the OpenID Connect identity provider.
+type UserIdentity struct {
+	ID          uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID      uuid.UUID `json:"-" gorm:"type:uuid;index"`
+	Issuer      string    `json:"issuer" gorm:"uniqueIndex:idx_user_identities_issuer_subject"`
+	Subject     string    `json:"subject" gorm:"uniqueIndex:idx_user_identities_issuer_subject"` // The "sub" claim, stable for the account
+	Email       string    `json:"email"`
+	LastLoginAt time.Time `json:"last_login_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// OIDCClaims are the claims of an ID token GForms uses.
+type OIDCClaims struct {
+	Subject           string    `json:"sub"`
+	Email             string    `json:"email"`
+	EmailVerified     claimBool `json:"email_verified"`
+	PreferredUsername string    `json:"preferred_username"`
+	Name              string    `json:"name"`
+	AuthTime          int64     `json:"auth_time"` // When the user authenticated, if the provider tells
+}
+
+// claimBool reads boolean claims, which some providers send as strings.
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	*b = claimBool(value == "true")
+	return nil
+}
+
+// --- Provider ---
+
+// OIDCClient is the OpenID Connect relying party: the discovered provider and the OAuth2 client.
+type OIDCClient struct {
+	OAuth2   oauth2.Config
+	Verifier *oidc.IDTokenVerifier
+}
+
+var (
+	oidcMu     sync.Mutex
+	oidcCached *OIDCClient
+)
+
+// oidcEnabled reports whether single sign-on is configured.
+func oidcEnabled() bool {
+	return AppConfig.OIDCIssuerURL != "" && AppConfig.OIDCClientID != ""
+}
+
+// oidcClient returns the OpenID Connect client, discovering the provider on first use.
+// Discovery is retried on the next login if it fails, so the backend starts even while
+// the identity provider is down.
+func oidcClient(ctx context.Context) (*OIDCClient, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcCached != nil {
+		return oidcCached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, AppConfig.OIDCIssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", AppConfig.OIDCIssuerURL, err)
+	}
+
+	redirectURL := AppConfig.OIDCRedirectURL
+	if redirectURL == "" {
+		redirectURL = publicLink("/api/account/oidc/callback")
+	}
+	oidcCached = &OIDCClient{
+		OAuth2: oauth2.Config{
+			ClientID:     AppConfig.OIDCClientID,
+			ClientSecret: AppConfig.OIDCClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       AppConfig.OIDCScopes,
+		},
+		Verifier: provider.Verifier(&oidc.Config{ClientID: AppConfig.OIDCClientID}),
+	}
+	return oidcCached, nil
+}
+
+// --- Database Functions ---
+
+// UserForIdentity returns the user linked to an identity of the provider. The first time
+// an identity signs in, it is linked to the user with the same email, or to a new user
+// if there is none and auto-provisioning is enabled. Either way the email must have been
+// verified by the provider, since it decides which account the identity gets.
+func UserForIdentity(issuer string, claims OIDCClaims) (*User, error) {
+	var user User
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var identity UserIdentity
+		if err := tx.Limit(1).Find(&identity, "issuer = ? AND subject = ?", issuer, claims.Subject).Error; err != nil {
+			return err
+		}
+
+		if identity.ID != uuid.Nil {
+			if err := tx.First(&user, "id = ?", identity.UserID).Error; err != nil {
+				return err
+			}
+		} else {
+			if claims.Email == "" || !claims.EmailVerified {
+				return ErrEmailNotVerified
+			}
+
+			if err := tx.Limit(1).Find(&user, "lower(email) = lower(?)", claims.Email).Error; err != nil {
+				return err
+			}
+			if user.ID == uuid.Nil {
+				if !AppConfig.OIDCAutoProvision {
+					return ErrProvisioningClosed
+				}
+				username, err := uniqueUsername(tx, claims.PreferredUsername, claims.Email)
+				if err != nil {
+					return err
+				}
+				// No local password: the user signs in through the provider, or resets one.
+				user = User{Username: username, Email: claims.Email, Verified: true}
+				if err := tx.Create(&user).Error; err != nil {
+					return err
+				}
+				log.Printf("Account %s created for %s at %s", user.Username, claims.Subject, issuer)
+			} else if !user.Verified {
+				// The provider verified the email, which is what the verification link proves.
+				if err := tx.Model(&user).Update("verified", true).Error; err != nil {
+					return err
+				}
+			}
+
+			identity = UserIdentity{UserID: user.ID, Issuer: issuer, Subject: claims.Subject}
+		}
+
+		identity.Email = claims.Email
+		identity.LastLoginAt = time.Now()
+		return tx.Save(&identity).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// uniqueUsername derives a free username from the preferred username of an identity,
+// or from the local part of its email, following ValidateUsername.
+func uniqueUsername(tx *gorm.DB, preferred, email string) (string, error) {
+	base := usernameInvalidChars.ReplaceAllString(preferred, "")
+	if len(base) < usernameMinLength {
+		local, _, _ := strings.Cut(email, "@")
+		base = usernameInvalidChars.ReplaceAllString(local, "")
+	}
+	if len(base) < usernameMinLength {
+		base = "user" + base
+	}
+	base = base[:min(len(base), usernameMaxLength-4)] // Room for a number
+
+	username := base
+	for i := 2; ; i++ {
+		taken, err := usernameTaken(tx, username, uuid.Nil)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return username, nil
+		}
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// --- Handlers ---
+
+// safeRedirect returns the frontend path to go back to after signing in. Only local
+// paths are accepted, so the login can't be used to send users to another site.
+func safeRedirect(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/forms"
+	}
+	return path
+}
+
+// recentSingleSignOn reports whether the user of the session authenticated with the
+// identity provider less than OIDC_REAUTH_MAX_AGE ago.
+func recentSingleSignOn(c *gin.Context) bool {
+	authTime, ok := sessions.Default(c).Get(sessionSSOAuthTimeKey).(int64)
+	return ok && time.Since(time.Unix(authTime, 0)) <= AppConfig.OIDCReauthMaxAge
+}
+
+// oidcLoginFailed sends the browser back to the sign in page of the frontend with ?sso=RESULT.
+func oidcLoginFailed(c *gin.Context, result string) {
+	c.Redirect(http.StatusSeeOther, frontendLink("/account/login?sso="+url.QueryEscape(result)))
+}
+
+// oidcInfoHandler handles GET /api/account/oidc requests, telling the frontend whether to show single sign-on.
+func oidcInfoHandler(c *gin.Context) {
+	if !oidcEnabled() {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "login_url": publicLink("/api/account/oidc/login")})
+}
+
+// oidcLoginHandler handles GET /api/account/oidc/login?redirect=/path requests. It starts
+// an authorization code flow with PKCE, sending the browser to the identity provider.
+// With ?reauth=true the provider is asked to authenticate the user again even if they
+// have a session there, to confirm a change to an account without a password.
+func oidcLoginHandler(c *gin.Context) {
+	if !oidcEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	client, err := oidcClient(c.Request.Context())
+	if err != nil {
+		log.Printf("Error setting up single sign-on: %v", err)
+		oidcLoginFailed(c, "unavailable")
+		return
+	}
+
+	state, _, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start single sign-on"})
+		return
+	}
+	nonce, _, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start single sign-on"})
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	session := sessions.Default(c)
+	session.Set(sessionOIDCStateKey, state)
+	session.Set(sessionOIDCNonceKey, nonce)
+	session.Set(sessionOIDCVerifierKey, verifier)
+	session.Set(sessionOIDCRedirectKey, safeRedirect(c.Query("redirect")))
+	reauth := c.Query("reauth") == "true"
+	session.Set(sessionOIDCReauthKey, reauth)
+	if err := session.Save(); err != nil {
+		log.Printf("Error saving single sign-on state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start single sign-on"})
+		return
+	}
+
+	options := []oauth2.AuthCodeOption{oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)}
+	if reauth {
+		options = append(options, oauth2.SetAuthURLParam("prompt", "login"), oauth2.SetAuthURLParam("max_age", "0"))
+	}
+	c.Redirect(http.StatusFound, client.OAuth2.AuthCodeURL(state, options...))
+}
+
+// oidcCallbackHandler handles GET /api/account/oidc/callback requests, where the identity
+// provider sends the browser back. It signs the user in and redirects to the frontend;
+// failures redirect to the sign in page with ?sso=RESULT. Users with two-factor
+// authentication enabled are sent to the sign in page to enter their code.
+func oidcCallbackHandler(c *gin.Context) {
+	if !oidcEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	session := sessions.Default(c)
+	state, _ := session.Get(sessionOIDCStateKey).(string)
+	nonce, _ := session.Get(sessionOIDCNonceKey).(string)
+	verifier, _ := session.Get(sessionOIDCVerifierKey).(string)
+	redirect, _ := session.Get(sessionOIDCRedirectKey).(string)
+	reauth, _ := session.Get(sessionOIDCReauthKey).(bool)
+
+	if errorCode := c.Query("error"); errorCode != "" {
+		log.Printf("Single sign-on refused by the identity provider: %s %s", errorCode, c.Query("error_description"))
+		oidcLoginFailed(c, "denied")
+		return
+	}
+	if state == "" || c.Query("state") != state {
+		oidcLoginFailed(c, "expired")
+		return
+	}
+
+	client, err := oidcClient(c.Request.Context())
+	if err != nil {
+		log.Printf("Error setting up single sign-on: %v", err)
+		oidcLoginFailed(c, "unavailable")
+		return
+	}
+
+	token, err := client.OAuth2.Exchange(c.Request.Context(), c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Printf("Error exchanging the single sign-on code: %v", err)
+		oidcLoginFailed(c, "error")
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Println("Error completing single sign-on: no id_token in the token response")
+		oidcLoginFailed(c, "error")
+		return
+	}
+	idToken, err := client.Verifier.Verify(c.Request.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		log.Printf("Error verifying the single sign-on ID token: %v", err)
+		oidcLoginFailed(c, "error")
+		return
+	}
+
+	var claims OIDCClaims
+	if err := idToken.Claims(&claims); err != nil {
+		log.Printf("Error reading the single sign-on ID token claims: %v", err)
+		oidcLoginFailed(c, "error")
+		return
+	}
+
+	user, err := UserForIdentity(idToken.Issuer, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrEmailNotVerified):
+			oidcLoginFailed(c, "unverified")
+		case errors.Is(err, ErrProvisioningClosed):
+			oidcLoginFailed(c, "no_account")
+		default:
+			log.Printf("Error signing in %s with single sign-on: %v", claims.Subject, err)
+			oidcLoginFailed(c, "error")
+		}
+		return
+	}
+
+	twoFactorRequired, err := signIn(c, user)
+	if err != nil {
+		log.Printf("Error saving session for user %s: %v", user.ID, err)
+		oidcLoginFailed(c, "error")
+		return
+	}
+	if twoFactorRequired {
+		c.Redirect(http.StatusSeeOther, frontendLink("/account/login?two_factor=required"))
+		return
+	}
+
+	// Providers that don't send auth_time have just authenticated the user if asked to.
+	authTime := claims.AuthTime
+	if authTime == 0 && reauth {
+		authTime = time.Now().Unix()
+	}
+	if authTime != 0 {
+		session.Set(sessionSSOAuthTimeKey, authTime)
+		if err := session.Save(); err != nil {
+			log.Printf("Error saving session for user %s: %v", user.ID, err)
+		}
+	}
+
+	c.Redirect(http.StatusSeeOther, frontendLink(safeRedirect(redirect)))
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Errors returned when the item to move is not part of the form.
+var (
+	ErrQuestionNotFound = errors.New("question not found")
+	ErrSectionNotFound  = errors.New("section not found")
+)
+
+// MoveRequest moves a question or a section to a new position.
+type MoveRequest struct {
+	SectionID *uuid.UUID `json:"section_id"`                  // Questions only: the section to move to, defaults to the current one
+	Position  *int       `json:"position" binding:"required"` // Position in the (target) section, or among sections, from 0
+}
+
+// --- Database Functions ---
+
+// MigrateQuestionPositions numbers the questions of sections created before positions
+// existed, in creation order. Sections whose questions are already numbered are left as they are.
+func MigrateQuestionPositions() error {
+	result := DB.Exec(`
+		UPDATE questions SET position = numbered.position
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY section_id ORDER BY created_at, id) - 1 AS position
+			FROM questions
+			WHERE deleted_at IS NULL AND section_id IN (
+				SELECT section_id FROM questions
+				WHERE deleted_at IS NULL
+				GROUP BY section_id
+				HAVING COUNT(*) > COUNT(DISTINCT position)
+			)
+		) AS numbered
+		WHERE questions.id = numbered.id`)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		log.Printf("Numbered the positions of %d questions", result.RowsAffected)
+	}
+	return nil
+}
+
+// lockEditableForm locks a form row for the rest of the transaction and loads its
+// sections and questions in order. The form must be a draft.
+func lockEditableForm(tx *gorm.DB, formID string) (*Form, error) {
+	var form Form
+	if err := withSections(tx.Clauses(clause.Locking{Strength: "UPDATE"})).First(&form, "id = ?", formID).Error; err != nil {
+		return nil, err
+	}
+	if !form.IsEditable() {
+		return nil, ErrFormNotEditable
+	}
+	return &form, nil
+}
+
+// MoveQuestion moves a question to a position within its section, or into another
+// section, shifting the questions around it. The move is rejected if it breaks the
+// form's conditions or jumps, which may only look backwards and go forwards.
+func MoveQuestion(formID string, questionID uuid.UUID, move MoveRequest) (*Form, error) {
+	var form *Form
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if form, err = lockEditableForm(tx, formID); err != nil {
+			return err
+		}
+
+		from, at := -1, -1
+		for i, section := range form.Sections {
+			if j := slices.IndexFunc(section.Questions, func(q Question) bool { return q.ID == questionID }); j >= 0 {
+				from, at = i, j
+			}
+		}
+		if from < 0 {
+			return ErrQuestionNotFound
+		}
+
+		to := from
+		if move.SectionID != nil {
+			to = slices.IndexFunc(form.Sections, func(s Section) bool { return s.ID == *move.SectionID })
+			if to < 0 {
+				return ErrSectionNotFound
+			}
+		}
+
+		question := form.Sections[from].Questions[at]
+		form.Sections[from].Questions = slices.Delete(form.Sections[from].Questions, at, at+1)
+
+		target := &form.Sections[to]
+		if *move.Position < 0 || *move.Position > len(target.Questions) {
+			return fmt.Errorf("%w: position must be between 0 and %d", ErrInvalidQuestions, len(target.Questions))
+		}
+		question.SectionID = &target.ID
+		target.Questions = slices.Insert(target.Questions, *move.Position, question)
+
+		form.collectQuestions()
+		if err := ValidateLogic(form.Questions); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidQuestions, err)
+		}
+
+		for _, i := range []int{from, to} {
+			if err := renumberQuestions(tx, &form.Sections[i]); err != nil {
+				return err
+			}
+		}
+		form.collectQuestions()
+
+		_, err = SnapshotForm(tx, form)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return form, nil
+}
+
+// MoveSection moves a section, with its questions, to a new position among the sections of its form.
+func MoveSection(formID string, sectionID uuid.UUID, move MoveRequest) (*Form, error) {
+	var form *Form
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if form, err = lockEditableForm(tx, formID); err != nil {
+			return err
+		}
+
+		at := slices.IndexFunc(form.Sections, func(s Section) bool { return s.ID == sectionID })
+		if at < 0 {
+			return ErrSectionNotFound
+		}
+		if *move.Position < 0 || *move.Position >= len(form.Sections) {
+			return fmt.Errorf("%w: position must be between 0 and %d", ErrInvalidQuestions, len(form.Sections)-1)
+		}
+
+		section := form.Sections[at]
+		form.Sections = slices.Delete(form.Sections, at, at+1)
+		form.Sections = slices.Insert(form.Sections, *move.Position, section)
+
+		form.collectQuestions()
+		if err := ValidateLogic(form.Questions); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidQuestions, err)
+		}
+
+		for i := range form.Sections {
+			if form.Sections[i].Position == i {
+				continue
+			}
+			form.Sections[i].Position = i
+			if err := tx.Model(&Section{}).Where("id = ?", form.Sections[i].ID).UpdateColumn("position", i).Error; err != nil {
+				return err
+			}
+		}
+
+		_, err = SnapshotForm(tx, form)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return form, nil
+}
+
+// renumberQuestions saves the section and position of the questions of a section
+// whose place changed, following their order in the slice.
+func renumberQuestions(tx *gorm.DB, section *Section) error {
+	for i := range section.Questions {
+		question := &section.Questions[i]
+		if question.Position == i && question.SectionID != nil && *question.SectionID == section.ID {
+			continue
+		}
+		question.Position = i
+		question.SectionID = &section.ID
+		if err := tx.Model(&Question{}).Where("id = ?", question.ID).
+			UpdateColumns(map[string]any{"position": i, "section_id": section.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- Handlers ---
+
+var (
+	moveQuestionHandler = moveHandler("questionId", MoveQuestion)
+	moveSectionHandler  = moveHandler("sectionId", MoveSection)
+)
+
+// moveHandler returns a handler for PATCH /forms/:formId/questions/:questionId/move
+// and PATCH /forms/:formId/sections/:sectionId/move, given the route parameter and the move to apply.
+func moveHandler(param string, apply func(formID string, id uuid.UUID, move MoveRequest) (*Form, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		formID := c.Param("formId")
+
+		id, err := uuid.Parse(c.Param(param))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+			return
+		}
+
+		var move MoveRequest
+		if err := c.ShouldBindJSON(&move); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or incomplete JSON request: " + err.Error()})
+			return
+		}
+
+		form, err := apply(formID, id, move)
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+			case errors.Is(err, ErrQuestionNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			case errors.Is(err, ErrSectionNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "Section not found"})
+			case errors.Is(err, ErrFormNotEditable):
+				c.JSON(http.StatusConflict, gin.H{"error": "Only draft forms can be edited, unpublish the form first"})
+			case errors.Is(err, ErrInvalidQuestions):
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			default:
+				log.Printf("Error moving %s of form %s: %v", id, formID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not move item"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, form)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/go-crypt/crypt"
+	"github.com/go-crypt/crypt/algorithm/argon2"
+	"github.com/google/uuid"
+)
+
+var hasher *argon2.Hasher
+
+// hashPolicy is the part of an encoded digest that PASSWORD_HASH_* determine: the
+// algorithm, its version and parameters, and the lengths of the encoded salt and key.
+type hashPolicy struct {
+	prefix    string // $argon2id$v=19$m=65536,t=3,p=4
+	saltChars int
+	keyChars  int
+}
+
+var currentHashPolicy hashPolicy
+
+// parseHashPolicy reads the policy a digest was made with, in the PHC string format
+// $variant$v=19$m=..,t=..,p=..$salt$key. ok is false for anything else, like the
+// digests of other algorithms.
+func parseHashPolicy(encoded string) (policy hashPolicy, ok bool) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 || fields[0] != "" {
+		return hashPolicy{}, false
+	}
+	return hashPolicy{
+		prefix:    strings.Join(fields[:4], "$"),
+		saltChars: len(fields[4]),
+		keyChars:  len(fields[5]),
+	}, true
+}
+
+// InitPasswordHasher sets up the hasher from the PASSWORD_HASH_* settings. The policy
+// digests are compared against is read from a digest of the hasher itself, so it is
+// encoded exactly like the digests it stores.
+func InitPasswordHasher() error {
+	var err error
+	hasher, err = argon2.New(
+		argon2.WithVariantName(AppConfig.PasswordHashVariant),
+		argon2.WithIterations(AppConfig.PasswordHashIterations),
+		argon2.WithMemoryInKiB(AppConfig.PasswordHashMemory),
+		argon2.WithParallelism(AppConfig.PasswordHashParallelism),
+		argon2.WithKeyLength(AppConfig.PasswordHashKeyLength),
+		argon2.WithSaltLength(AppConfig.PasswordHashSaltLength),
+	)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := HashPassword("")
+	if err != nil {
+		return err
+	}
+	currentHashPolicy, _ = parseHashPolicy(encoded)
+	log.Printf("Password hashing policy: %s", currentHashPolicy.prefix)
+	return nil
+}
+
+// HashPassword returns the encoded digest of a password under the current policy.
+func HashPassword(password string) (string, error) {
+	digest, err := hasher.Hash(password)
+	if err != nil {
+		return "", err
+	}
+	return digest.Encode(), nil
+}
+
+// NeedsRehash reports whether a digest was made with another algorithm, or other
+// parameters, than the current policy. Lowered parameters count too, so the settings
+// can be rolled back.
+func NeedsRehash(encoded string) bool {
+	policy, ok := parseHashPolicy(encoded)
+	return !ok || policy != currentHashPolicy
+}
+
+// CheckPassword reports whether the password matches the encoded digest, and whether
+// the digest should be replaced by a new one under the current policy.
+func CheckPassword(password, encoded string) (valid, rehash bool, err error) {
+	if valid, err = crypt.CheckPassword(password, encoded); err != nil || !valid {
+		return false, false, err
+	}
+	return true, NeedsRehash(encoded), nil
+}
+
+// checkUserPassword reports whether the password is the one of the user. The password
+// is only known now, so a digest made under an older policy is upgraded.
+func checkUserPassword(user *User, password string) bool {
+	valid, rehash, err := CheckPassword(password, user.Password)
+	if err != nil || !valid {
+		return false
+	}
+	if rehash {
+		if err := RehashPassword(user.ID, password, user.Password); err != nil {
+			log.Printf("Error upgrading password digest of user %s: %v", user.ID, err)
+		}
+	}
+	return true
+}
+
+// --- Database Functions ---
+
+// RehashPassword replaces the stored digest of a user with one of the password under the
+// current policy. The password must have been checked against oldDigest: if it has
+// changed meanwhile, the new one is kept.
+func RehashPassword(userID uuid.UUID, password, oldDigest string) error {
+	encoded, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	result := DB.Model(&User{}).Where("id = ? AND password = ?", userID, oldDigest).Update("password", encoded)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Password digest of user %s upgraded to the current policy", userID)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import "testing"
+
+// usePasswordHashPolicy sets up the hasher with cheap Argon2 parameters, changed by edit.
+func usePasswordHashPolicy(t *testing.T, edit func(config *Config)) {
+	t.Helper()
+	AppConfig.PasswordHashVariant = "argon2id"
+	AppConfig.PasswordHashIterations = 2
+	AppConfig.PasswordHashMemory = 8 * 1024
+	AppConfig.PasswordHashParallelism = 1
+	AppConfig.PasswordHashKeyLength = 32
+	AppConfig.PasswordHashSaltLength = 16
+	edit(&AppConfig)
+	if err := InitPasswordHasher(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func savePasswordHashPolicy(t *testing.T) {
+	savedConfig, savedHasher, savedPolicy := AppConfig, hasher, currentHashPolicy
+	t.Cleanup(func() { AppConfig, hasher, currentHashPolicy = savedConfig, savedHasher, savedPolicy })
+}
+
+func TestNeedsRehash(t *testing.T) {
+	savePasswordHashPolicy(t)
+
+	tests := []struct {
+		name   string
+		policy func(config *Config) // Policy the digest is made with, if any
+		digest string
+		want   bool
+	}{
+		{name: "current policy", policy: func(config *Config) {}},
+		{name: "more iterations", policy: func(config *Config) { config.PasswordHashIterations = 3 }, want: true},
+		{name: "fewer iterations", policy: func(config *Config) { config.PasswordHashIterations = 1 }, want: true},
+		{name: "other memory", policy: func(config *Config) { config.PasswordHashMemory = 16 * 1024 }, want: true},
+		{name: "other parallelism", policy: func(config *Config) { config.PasswordHashParallelism = 2 }, want: true},
+		{name: "other variant", policy: func(config *Config) { config.PasswordHashVariant = "argon2i" }, want: true},
+		{name: "other salt length", policy: func(config *Config) { config.PasswordHashSaltLength = 8 }, want: true},
+		{name: "other key length", policy: func(config *Config) { config.PasswordHashKeyLength = 16 }, want: true},
+		{name: "bcrypt digest", digest: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", want: true},
+		{name: "sha512crypt digest", digest: "$6$rounds=5000$saltsalt$hash", want: true},
+		{name: "empty", digest: "", want: true},
+		{name: "malformed", digest: "not a digest", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest := tt.digest
+			if tt.policy != nil {
+				usePasswordHashPolicy(t, tt.policy)
+				var err error
+				if digest, err = HashPassword("correct horse"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			usePasswordHashPolicy(t, func(config *Config) {})
+			if got := NeedsRehash(digest); got != tt.want {
+				t.Errorf("NeedsRehash(%q) = %v, want %v", digest, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	savePasswordHashPolicy(t)
+
+	usePasswordHashPolicy(t, func(config *Config) { config.PasswordHashIterations = 1 })
+	oldDigest, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usePasswordHashPolicy(t, func(config *Config) {})
+	currentDigest, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		password   string
+		digest     string
+		wantValid  bool
+		wantRehash bool
+		wantErr    bool
+	}{
+		{name: "current digest", password: "correct horse", digest: currentDigest, wantValid: true},
+		{name: "outdated digest", password: "correct horse", digest: oldDigest, wantValid: true, wantRehash: true},
+		{name: "wrong password", password: "battery staple", digest: currentDigest},
+		{name: "wrong password, outdated digest", password: "battery staple", digest: oldDigest},
+		{name: "malformed digest", password: "correct horse", digest: "not a digest", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, rehash, err := CheckPassword(tt.password, tt.digest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckPassword() error = %v, want error %v", err, tt.wantErr)
+			}
+			if valid != tt.wantValid || rehash != tt.wantRehash {
+				t.Errorf("CheckPassword() = (%v, %v), want (%v, %v)", valid, rehash, tt.wantValid, tt.wantRehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// commonPasswords are refused whatever the password policy.
+//
+//go:embed common-passwords.txt
+var commonPasswords string
+
+// breachedPasswords holds the uppercase SHA-1 of the passwords refused as breached,
+// loaded by InitPasswordPolicy.
+var breachedPasswords = map[string]struct{}{}
+
+// PasswordPolicyError tells why a password doesn't follow the password policy.
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (err *PasswordPolicyError) Error() string {
+	return err.Reason
+}
+
+// ValidatePassword checks a password against the password policy of AppConfig. The
+// username and email of the account, when given, must not be part of it.
+func ValidatePassword(password, username, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < AppConfig.PasswordMinLength {
+		return &PasswordPolicyError{fmt.Sprintf("password must be at least %d characters long", AppConfig.PasswordMinLength)}
+	}
+	if AppConfig.PasswordMaxLength > 0 && length > AppConfig.PasswordMaxLength {
+		return &PasswordPolicyError{fmt.Sprintf("password must be at most %d characters long", AppConfig.PasswordMaxLength)}
+	}
+	if classes := characterClasses(password); classes < AppConfig.PasswordMinClasses {
+		return &PasswordPolicyError{fmt.Sprintf("password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", AppConfig.PasswordMinClasses)}
+	}
+
+	lower := strings.ToLower(password)
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	for _, part := range []string{strings.ToLower(username), local} {
+		if len(part) >= usernameMinLength && strings.Contains(lower, part) {
+			return &PasswordPolicyError{"password must not contain your username or email"}
+		}
+	}
+
+	if passwordBreached(password) {
+		return &PasswordPolicyError{"this password is too common or appeared in a data breach, choose another one"}
+	}
+	return nil
+}
+
+// characterClasses counts the classes of characters a password uses, among lowercase
+// letters, uppercase letters, digits and symbols.
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// passwordHash returns the key a password is looked up by in breachedPasswords.
+func passwordHash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// passwordBreached reports whether a password is in the common or breached lists.
+// Common passwords are refused whatever their case.
+func passwordBreached(password string) bool {
+	for _, candidate := range []string{password, strings.ToLower(password)} {
+		if _, ok := breachedPasswords[passwordHash(candidate)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// --- Password Policy ---
+
+// InitPasswordPolicy loads the lists of refused passwords: the embedded common passwords,
+// and the file of PASSWORD_BREACHED_LIST if set.
+func InitPasswordPolicy() {
+	if err := loadPasswordList(strings.NewReader(commonPasswords), true); err != nil {
+		log.Fatalf("Failed to load the common passwords: %v", err)
+	}
+
+	if AppConfig.PasswordBreachedList != "" {
+		file, err := os.Open(AppConfig.PasswordBreachedList)
+		if err != nil {
+			log.Fatalf("Failed to open PASSWORD_BREACHED_LIST: %v", err)
+		}
+		defer file.Close()
+		if err := loadPasswordList(file, false); err != nil {
+			log.Fatalf("Failed to load PASSWORD_BREACHED_LIST: %v", err)
+		}
+	}
+	log.Printf("Password policy set up, %d passwords refused", len(breachedPasswords))
+}
+
+// loadPasswordList adds the passwords of a list to breachedPasswords. Lines are
+// passwords, or SHA-1 hashes in hexadecimal optionally followed by ":COUNT", the format
+// of the Pwned Passwords downloads. Empty lines and lines starting with # are skipped.
+func loadPasswordList(r io.Reader, lowercase bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); len(hash) == sha1.Size*2 && isHex(hash) {
+			breachedPasswords[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		if lowercase {
+			line = strings.ToLower(line)
+		}
+		breachedPasswords[passwordHash(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
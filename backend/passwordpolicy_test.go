@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePassword(t *testing.T) {
+	savedConfig, savedList := AppConfig, breachedPasswords
+	t.Cleanup(func() { AppConfig, breachedPasswords = savedConfig, savedList })
+
+	AppConfig.PasswordMinLength = 8
+	AppConfig.PasswordMaxLength = 20
+	AppConfig.PasswordMinClasses = 3
+	breachedPasswords = map[string]struct{}{}
+	list := "# common\nPassword1!\n\n" + passwordHash("Tr0ub4dor&3") + ":42\n"
+	if err := loadPasswordList(strings.NewReader(list), true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{name: "follows the policy", password: "Blue-Horse7"},
+		{name: "too short", password: "Ab1!", wantErr: "at least 8 characters"},
+		{name: "length counts characters, not bytes", password: "É" + strings.Repeat("é", 16) + "-9a"},
+		{name: "too long", password: "Blue-Horse7-Blue-Horse7", wantErr: "at most 20 characters"},
+		{name: "too few classes", password: "bluehorse7", wantErr: "mix at least 3"},
+		{name: "contains the username", password: "Xx-Ada_Lovelace-1", wantErr: "must not contain your username or email"},
+		{name: "contains the username in another case", password: "ADA_LOVELACE-x1", wantErr: "must not contain your username or email"},
+		{name: "contains the email local part", password: "Countess-7!", wantErr: "must not contain your username or email"},
+		{name: "common password", password: "Password1!", wantErr: "too common or appeared in a data breach"},
+		{name: "common password in another case", password: "PASSWORD1!", wantErr: "too common or appeared in a data breach"},
+		{name: "breached password listed by hash", password: "Tr0ub4dor&3", wantErr: "too common or appeared in a data breach"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword(tt.password, "ada_lovelace", "countess@example.com")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidatePassword(%q) = %v, want nil", tt.password, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidatePassword(%q) = %v, want an error containing %q", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePasswordShortIdentifiers(t *testing.T) {
+	savedConfig := AppConfig
+	t.Cleanup(func() { AppConfig = savedConfig })
+	AppConfig.PasswordMinLength = 8
+	AppConfig.PasswordMinClasses = 1
+
+	// Parts shorter than a username can be would refuse too many passwords
+	if err := ValidatePassword("jo-jo-jo-jo", "jo", "jo@example.com"); err != nil {
+		t.Errorf("ValidatePassword() = %v, want nil", err)
+	}
+}
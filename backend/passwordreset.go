@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// VerificationPurpose tells what a Verification token proves.
+type VerificationPurpose string
+
+const (
+	VerificationPurposeEmail         VerificationPurpose = "email"          // The user owns the email address they signed up with
+	VerificationPurposePasswordReset VerificationPurpose = "password_reset" // The user may choose a new password
+)
+
+// Errors returned when a token can't be used.
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+type PasswordForgotRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type PasswordResetRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// newToken returns a random token to hand to the user and its hash, which is all that is stored.
+func newToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hash a token is stored and looked up by.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// --- Database Functions ---
+
+// RequestPasswordReset emails a password reset link to the user with the given email,
+// if there is one, replacing the links sent before. Only the hash of the token is kept.
+// A link is sent at most once every PASSWORD_RESET_INTERVAL per account, and
+// PASSWORD_RESET_IP_MAX links an hour per address the requests come from. Over the
+// account limit nothing is sent but no error is returned either, so the answer doesn't
+// tell which emails have accounts.
+func RequestPasswordReset(email, ip string, now time.Time) error {
+	if err := checkTokenRequestAllowed(VerificationPurposePasswordReset, ip, AppConfig.PasswordResetIPMax, now); err != nil {
+		return err
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		// Locking the user serializes concurrent requests for the same account
+		var user User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Limit(1).Find(&user, "lower(email) = lower(?)", strings.TrimSpace(email)).Error
+		if err != nil || user.ID == uuid.Nil {
+			return err
+		}
+
+		// Tokens that were replaced or used count too
+		var recent int64
+		err = tx.Unscoped().Model(&Verification{}).
+			Where("user_id = ? AND purpose = ? AND created_at > ?", user.ID, VerificationPurposePasswordReset, now.Add(-AppConfig.PasswordResetInterval)).
+			Count(&recent).Error
+		if err != nil || recent > 0 {
+			return err
+		}
+
+		if err := tx.Where("user_id = ? AND purpose = ?", user.ID, VerificationPurposePasswordReset).Delete(&Verification{}).Error; err != nil {
+			return err
+		}
+		verification := Verification{
+			UserID:    user.ID,
+			Purpose:   VerificationPurposePasswordReset,
+			TokenHash: hash,
+			RequestIP: ip,
+			ExpiresAt: now.Add(AppConfig.PasswordResetTTL),
+		}
+		if err := tx.Create(&verification).Error; err != nil {
+			return err
+		}
+		return QueueEmail(tx, MailPasswordReset, user.Email, PasswordResetMail{
+			Username:  user.Username,
+			Link:      frontendLink("/account/reset-password?token=" + token),
+			ExpiresAt: verification.ExpiresAt,
+		})
+	})
+}
+
+// tokenRequestWindow is the period the per address limits on token requests count over.
+const tokenRequestWindow = time.Hour
+
+// TokenRequestLimitedError is returned while tokens can't be requested from an address.
+type TokenRequestLimitedError struct {
+	Wait time.Duration
+}
+
+func (err *TokenRequestLimitedError) Error() string {
+	return "too many tokens requested, retry in " + err.Wait.String()
+}
+
+// checkTokenRequestAllowed returns a TokenRequestLimitedError if max tokens for the purpose
+// were requested from the address in the last hour. Tokens that were replaced or used
+// count too. A max of 0 means no limit.
+func checkTokenRequestAllowed(purpose VerificationPurpose, ip string, max int, now time.Time) error {
+	if max <= 0 {
+		return nil
+	}
+
+	var recent []time.Time
+	err := DB.Unscoped().Model(&Verification{}).
+		Where("purpose = ? AND request_ip = ? AND created_at > ?", purpose, ip, now.Add(-tokenRequestWindow)).
+		Order("created_at DESC").Limit(max).Pluck("created_at", &recent).Error
+	if err != nil {
+		return err
+	}
+	if len(recent) < max {
+		return nil
+	}
+	// Once the oldest of the recent tokens leaves the window, another one can be issued
+	return &TokenRequestLimitedError{Wait: recent[len(recent)-1].Add(tokenRequestWindow).Sub(now)}
+}
+
+// setRetryAfter tells the client how long to wait before trying again.
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// ResetPassword sets a new password for the user a reset token was issued to. The token
+// is used up, and every session and API token of the user is revoked so a stolen session
+// or token dies with the old password. The password is checked and hashed before the
+// token is locked, so no lock is held while hashing.
+func ResetPassword(token, password string) error {
+	var verification Verification
+	err := DB.Limit(1).Find(&verification, "token_hash = ? AND purpose = ?", hashToken(token), VerificationPurposePasswordReset).Error
+	if err != nil {
+		return err
+	}
+	if verification.ID == 0 {
+		return ErrInvalidToken
+	}
+	if time.Now().After(verification.ExpiresAt) {
+		return ErrTokenExpired
+	}
+
+	var user User
+	if err := DB.First(&user, "id = ?", verification.UserID).Error; err != nil {
+		return err
+	}
+	if err := ValidatePassword(password, user.Username, user.Email); err != nil {
+		return err // The token stays usable, to try another password
+	}
+	passwordDigest, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		// The token may have been used meanwhile
+		var locked Verification
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Limit(1).Find(&locked, "id = ?", verification.ID).Error; err != nil {
+			return err
+		}
+		if locked.ID == 0 {
+			return ErrInvalidToken
+		}
+		if err := tx.Delete(&locked).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Update("password", passwordDigest).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&UserSession{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&APIToken{}).Error; err != nil {
+			return err
+		}
+		return QueueEmail(tx, MailNotification, user.Email, NotificationMail{
+			Username:  user.Username,
+			Title:     "Your GForms password was changed",
+			Message:   "The password of your account was just reset: you were signed out everywhere and your API tokens were revoked. If you didn't do it, reset your password again and check your email account.",
+			Link:      frontendLink("/account/login"),
+			LinkLabel: "Sign in",
+		})
+	})
+}
+
+// --- Handlers ---
+
+// passwordForgotHandler handles POST /api/account/password/forgot requests, limited as
+// RequestPasswordReset tells. The answer is the same whether or not an account has the
+// email, so it can't be used to find accounts.
+func passwordForgotHandler(c *gin.Context) {
+	var forgotRequest PasswordForgotRequest
+	if err := c.ShouldBindJSON(&forgotRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := RequestPasswordReset(forgotRequest.Email, c.ClientIP(), time.Now()); err != nil {
+		var limited *TokenRequestLimitedError
+		if errors.As(err, &limited) {
+			setRetryAfter(c, limited.Wait)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many password resets were requested, try again later"})
+			return
+		}
+		log.Printf("Error requesting password reset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not request a password reset"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an account uses this email, a password reset link has been sent to it"})
+}
+
+// passwordResetHandler handles POST /api/account/password/reset requests.
+func passwordResetHandler(c *gin.Context) {
+	var resetRequest PasswordResetRequest
+	if err := c.ShouldBindJSON(&resetRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ResetPassword(resetRequest.Token, resetRequest.Password); err != nil {
+		var policyErr *PasswordPolicyError
+		switch {
+		case errors.As(err, &policyErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": policyErr.Error()})
+		case errors.Is(err, ErrInvalidToken):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password reset token"})
+		case errors.Is(err, ErrTokenExpired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password reset token has expired"})
+		default:
+			log.Printf("Error resetting password: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, sign in with the new password"})
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"github.com/google/uuid"
+)
+
+// DateLayout is the format of the dates used in question configurations and answers.
+const DateLayout = "2006-01-02"
+
+// Choice is a selectable option of a choice question. Its ID is stable across
+// edits, so answers keep referring to the same option when its label changes.
+type Choice struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// QuestionConfig holds the type-specific settings of a question.
+// Each question type only accepts the fields that make sense for it.
+type QuestionConfig struct {
+	Placeholder   string   `json:"placeholder,omitempty"`
+	Choices       []Choice `json:"choices,omitempty"`
+	MinLength     *int     `json:"min_length,omitempty"`
+	MaxLength     *int     `json:"max_length,omitempty"`
+	Pattern       string   `json:"pattern,omitempty"`
+	Rows          *int     `json:"rows,omitempty"`
+	Min           *float64 `json:"min,omitempty"`
+	Max           *float64 `json:"max,omitempty"`
+	Step          *float64 `json:"step,omitempty"`
+	MinDate       string   `json:"min_date,omitempty"`
+	MaxDate       string   `json:"max_date,omitempty"`
+	MinSelections *int     `json:"min_selections,omitempty"`
+	MaxSelections *int     `json:"max_selections,omitempty"`
+}
+
+// setFields returns the JSON names of the configuration fields that are set.
+func (config *QuestionConfig) setFields() []string {
+	var fields []string
+	add := func(set bool, name string) {
+		if set {
+			fields = append(fields, name)
+		}
+	}
+	add(config.Placeholder != "", "placeholder")
+	add(len(config.Choices) > 0, "choices")
+	add(config.MinLength != nil, "min_length")
+	add(config.MaxLength != nil, "max_length")
+	add(config.Pattern != "", "pattern")
+	add(config.Rows != nil, "rows")
+	add(config.Min != nil, "min")
+	add(config.Max != nil, "max")
+	add(config.Step != nil, "step")
+	add(config.MinDate != "", "min_date")
+	add(config.MaxDate != "", "max_date")
+	add(config.MinSelections != nil, "min_selections")
+	add(config.MaxSelections != nil, "max_selections")
+	return fields
+}
+
+// ChoiceByID returns the choice with the given ID, if the question has one.
+func (config *QuestionConfig) ChoiceByID(id string) (Choice, bool) {
+	for _, choice := range config.Choices {
+		if choice.ID == id {
+			return choice, true
+		}
+	}
+	return Choice{}, false
+}
+
+// QuestionType describes a kind of question the API accepts.
+type QuestionType interface {
+	// ConfigFields lists the configuration fields the type accepts.
+	ConfigFields() []string
+	// ValidateConfig checks the configuration of a question of this type.
+	// It may normalize it, for instance by assigning IDs to new choices.
+	ValidateConfig(config *QuestionConfig) error
+	// MultiValue reports whether answers carry a list of values in Answer.Values
+	// instead of a single Answer.Value.
+	MultiValue() bool
+	// ValidateAnswer checks a non-empty answer against the question's configuration.
+	ValidateAnswer(config *QuestionConfig, answer *Answer) error
+}
+
+// questionTypes is the registry of the allowed question types, keyed by the name used in Question.Type.
+var questionTypes = map[string]QuestionType{}
+
+// RegisterQuestionType makes a question type available under the given name.
+func RegisterQuestionType(name string, questionType QuestionType) {
+	if _, exists := questionTypes[name]; exists {
+		panic("question type already registered: " + name)
+	}
+	questionTypes[name] = questionType
+}
+
+// LookupQuestionType returns the question type registered under the given name.
+func LookupQuestionType(name string) (QuestionType, bool) {
+	questionType, ok := questionTypes[name]
+	return questionType, ok
+}
+
+// QuestionTypeNames returns the names of the registered question types, sorted.
+func QuestionTypeNames() []string {
+	names := make([]string, 0, len(questionTypes))
+	for name := range questionTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterQuestionType("text", textQuestion{})
+	RegisterQuestionType("textarea", textQuestion{multiline: true})
+	RegisterQuestionType("email", emailQuestion{})
+	RegisterQuestionType("number", numberQuestion{})
+	RegisterQuestionType("date", dateQuestion{})
+	RegisterQuestionType("select", choiceQuestion{withPlaceholder: true})
+	RegisterQuestionType("radio", choiceQuestion{})
+	RegisterQuestionType("checkbox", choiceQuestion{multiple: true})
+	RegisterQuestionType("multiselect", choiceQuestion{multiple: true})
+}
+
+// ValidateQuestion checks that the question has a registered type and a valid
+// configuration for it. Questions without a type default to "text".
+func ValidateQuestion(question *Question) error {
+	if question.Type == "" {
+		question.Type = "text"
+	}
+
+	questionType, ok := LookupQuestionType(question.Type)
+	if !ok {
+		return fmt.Errorf("unknown question type %q (allowed: %s)", question.Type, strings.Join(QuestionTypeNames(), ", "))
+	}
+
+	accepted := map[string]bool{}
+	for _, field := range questionType.ConfigFields() {
+		accepted[field] = true
+	}
+	for _, field := range question.Config.setFields() {
+		if !accepted[field] {
+			return fmt.Errorf("%s questions do not accept the %q setting", question.Type, field)
+		}
+	}
+
+	return questionType.ValidateConfig(&question.Config)
+}
+
+// --- Database Functions ---
+
+// MigrateQuestionExtraInfo converts the comma-separated options of the legacy
+// extra_info column into choices with stable IDs, then drops the column.
+func MigrateQuestionExtraInfo() error {
+	if !DB.Migrator().HasColumn(&Question{}, "extra_info") {
+		return nil
+	}
+
+	var legacy []struct {
+		ID        uuid.UUID
+		Type      string
+		ExtraInfo string
+	}
+	if err := DB.Table("questions").Select("id, type, extra_info").Where("extra_info <> ''").Scan(&legacy).Error; err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, row := range legacy {
+			if !acceptsConfigField(row.Type, "choices") {
+				continue
+			}
+
+			var config QuestionConfig
+			for _, label := range strings.Split(row.ExtraInfo, ",") {
+				if label = strings.TrimSpace(label); label != "" {
+					config.Choices = append(config.Choices, Choice{ID: uuid.NewString(), Label: label})
+				}
+			}
+
+			err := tx.Unscoped().Model(&Question{ID: row.ID}).Select("config").Updates(&Question{Config: config}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Migrated options of %d questions from extra_info", len(legacy))
+		return tx.Migrator().DropColumn(&Question{}, "extra_info")
+	})
+}
+
+// MigrateMultiValueAnswers moves the answers of multi-valued questions that were
+// stored as delimited strings in Answer.Value into Answer.Values. Each piece is
+// matched against the question's choices by ID, then by label.
+func MigrateMultiValueAnswers() error {
+	var multiValueTypes []string
+	for _, name := range QuestionTypeNames() {
+		if questionTypes[name].MultiValue() {
+			multiValueTypes = append(multiValueTypes, name)
+		}
+	}
+
+	var legacy []struct {
+		ID         uuid.UUID
+		QuestionID uuid.UUID
+		Value      string
+	}
+	err := DB.Table("answers").
+		Select("answers.id, answers.question_id, answers.value").
+		Joins("JOIN questions ON questions.id = answers.question_id").
+		Where("questions.type IN ? AND answers.value <> '' AND answers.selected_values IS NULL", multiValueTypes).
+		Scan(&legacy).Error
+	if err != nil || len(legacy) == 0 {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		questions := map[uuid.UUID]*Question{}
+		for _, row := range legacy {
+			question, ok := questions[row.QuestionID]
+			if !ok {
+				question = &Question{}
+				if err := tx.Unscoped().First(question, "id = ?", row.QuestionID).Error; err != nil {
+					return err
+				}
+				questions[row.QuestionID] = question
+			}
+
+			values := []string{}
+			for _, piece := range strings.Split(row.Value, ",") {
+				if piece = strings.TrimSpace(piece); piece != "" {
+					values = append(values, legacyChoiceID(&question.Config, piece))
+				}
+			}
+
+			err := tx.Unscoped().Model(&Answer{ID: row.ID}).
+				Select("Value", "Values").
+				Updates(&Answer{Value: "", Values: values}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Migrated %d multi-valued answers to lists", len(legacy))
+		return nil
+	})
+}
+
+// legacyChoiceID returns the ID of the choice a legacy answer refers to, by ID or by label.
+// Unknown values are kept as they are.
+func legacyChoiceID(config *QuestionConfig, value string) string {
+	if _, ok := config.ChoiceByID(value); ok {
+		return value
+	}
+	for _, choice := range config.Choices {
+		if choice.Label == value {
+			return choice.ID
+		}
+	}
+	return value
+}
+
+func acceptsConfigField(typeName, field string) bool {
+	questionType, ok := LookupQuestionType(typeName)
+	if !ok {
+		return false
+	}
+	for _, accepted := range questionType.ConfigFields() {
+		if accepted == field {
+			return true
+		}
+	}
+	return false
+}
+
+// --- Question Types ---
+
+// singleValue is embedded by the question types whose answers hold a single value.
+type singleValue struct{}
+
+func (singleValue) MultiValue() bool {
+	return false
+}
+
+type textQuestion struct {
+	singleValue
+	multiline bool
+}
+
+func (t textQuestion) ConfigFields() []string {
+	fields := []string{"placeholder", "min_length", "max_length", "pattern"}
+	if t.multiline {
+		fields = append(fields, "rows")
+	}
+	return fields
+}
+
+func (t textQuestion) ValidateConfig(config *QuestionConfig) error {
+	if err := validateLengths(config); err != nil {
+		return err
+	}
+	if config.Pattern != "" {
+		if _, err := regexp.Compile(config.Pattern); err != nil {
+			return fmt.Errorf("pattern is not a valid regular expression: %v", err)
+		}
+	}
+	if config.Rows != nil && (*config.Rows < 1 || *config.Rows > 50) {
+		return errors.New("rows must be between 1 and 50")
+	}
+	return nil
+}
+
+func (t textQuestion) ValidateAnswer(config *QuestionConfig, answer *Answer) error {
+	value := answer.Value
+	if err := checkLength(config, value); err != nil {
+		return err
+	}
+	if config.Pattern != "" {
+		// Like the HTML pattern attribute, the expression must match the whole answer.
+		matched, err := regexp.MatchString("^(?:"+config.Pattern+")$", value)
+		if err != nil || !matched {
+			return errors.New("does not match the expected format")
+		}
+	}
+	return nil
+}
+
+type emailQuestion struct{ singleValue }
+
+func (emailQuestion) ConfigFields() []string {
+	return []string{"placeholder", "max_length"}
+}
+
+func (emailQuestion) ValidateConfig(config *QuestionConfig) error {
+	return validateLengths(config)
+}
+
+func (emailQuestion) ValidateAnswer(config *QuestionConfig, answer *Answer) error {
+	value := answer.Value
+	if err := checkLength(config, value); err != nil {
+		return err
+	}
+	// Only bare addresses are accepted, not "Name <address>" forms.
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value {
+		return errors.New("must be a valid email address")
+	}
+	// ParseAddress accepts single-label domains such as "user@localhost".
+	if domain := value[strings.LastIndex(value, "@")+1:]; !strings.Contains(domain, ".") {
+		return errors.New("must be a valid email address")
+	}
+	return nil
+}
+
+type numberQuestion struct{ singleValue }
+
+func (numberQuestion) ConfigFields() []string {
+	return []string{"placeholder", "min", "max", "step"}
+}
+
+func (numberQuestion) ValidateConfig(config *QuestionConfig) error {
+	if config.Min != nil && config.Max != nil && *config.Min > *config.Max {
+		return errors.New("min must not be greater than max")
+	}
+	if config.Step != nil && *config.Step <= 0 {
+		return errors.New("step must be greater than zero")
+	}
+	return nil
+}
+
+func (numberQuestion) ValidateAnswer(config *QuestionConfig, answer *Answer) error {
+	value := answer.Value
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+		return errors.New("must be a number")
+	}
+	if config.Min != nil && number < *config.Min {
+		return fmt.Errorf("must be at least %s", formatNumber(*config.Min))
+	}
+	if config.Max != nil && number > *config.Max {
+		return fmt.Errorf("must be at most %s", formatNumber(*config.Max))
+	}
+	if config.Step != nil {
+		base := 0.0
+		if config.Min != nil {
+			base = *config.Min
+		}
+		steps := (number - base) / *config.Step
+		if math.Abs(steps-math.Round(steps)) > 1e-9 {
+			return fmt.Errorf("must be a multiple of %s", formatNumber(*config.Step))
+		}
+	}
+	return nil
+}
+
+type dateQuestion struct{ singleValue }
+
+func (dateQuestion) ConfigFields() []string {
+	return []string{"min_date", "max_date"}
+}
+
+func (dateQuestion) ValidateConfig(config *QuestionConfig) error {
+	var minDate, maxDate time.Time
+	var err error
+	if config.MinDate != "" {
+		if minDate, err = time.Parse(DateLayout, config.MinDate); err != nil {
+			return errors.New("min_date must be formatted as YYYY-MM-DD")
+		}
+	}
+	if config.MaxDate != "" {
+		if maxDate, err = time.Parse(DateLayout, config.MaxDate); err != nil {
+			return errors.New("max_date must be formatted as YYYY-MM-DD")
+		}
+	}
+	if config.MinDate != "" && config.MaxDate != "" && minDate.After(maxDate) {
+		return errors.New("min_date must not be after max_date")
+	}
+	return nil
+}
+
+func (dateQuestion) ValidateAnswer(config *QuestionConfig, answer *Answer) error {
+	value := answer.Value
+	if _, err := time.Parse(DateLayout, value); err != nil {
+		return errors.New("must be a date formatted as YYYY-MM-DD")
+	}
+	// Dates in DateLayout compare chronologically as strings.
+	if config.MinDate != "" && value < config.MinDate {
+		return fmt.Errorf("must not be before %s", config.MinDate)
+	}
+	if config.MaxDate != "" && value > config.MaxDate {
+		return fmt.Errorf("must not be after %s", config.MaxDate)
+	}
+	return nil
+}
+
+type choiceQuestion struct {
+	multiple        bool
+	withPlaceholder bool
+}
+
+// maxChoices bounds the number of options of a single question.
+const maxChoices = 100
+
+func (t choiceQuestion) ConfigFields() []string {
+	fields := []string{"choices"}
+	if t.withPlaceholder {
+		fields = append(fields, "placeholder")
+	}
+	if t.multiple {
+		fields = append(fields, "min_selections", "max_selections")
+	}
+	return fields
+}
+
+func (t choiceQuestion) ValidateConfig(config *QuestionConfig) error {
+	if len(config.Choices) == 0 {
+		return errors.New("at least one choice is required")
+	}
+	if len(config.Choices) > maxChoices {
+		return fmt.Errorf("too many choices (max %d)", maxChoices)
+	}
+
+	ids := map[string]bool{}
+	for i := range config.Choices {
+		choice := &config.Choices[i]
+		choice.Label = strings.TrimSpace(choice.Label)
+		if choice.Label == "" {
+			return fmt.Errorf("choice %d has an empty label", i+1)
+		}
+		if choice.ID == "" {
+			choice.ID = uuid.NewString() // New choice: assign its stable ID
+		}
+		if ids[choice.ID] {
+			return fmt.Errorf("duplicate choice id %q", choice.ID)
+		}
+		ids[choice.ID] = true
+	}
+
+	minSelections, maxSelections := 0, len(config.Choices)
+	if config.MinSelections != nil {
+		minSelections = *config.MinSelections
+	}
+	if config.MaxSelections != nil {
+		maxSelections = *config.MaxSelections
+	}
+	if minSelections < 0 || maxSelections < 1 || minSelections > maxSelections || maxSelections > len(config.Choices) {
+		return fmt.Errorf("selections must satisfy 0 <= min_selections <= max_selections <= %d", len(config.Choices))
+	}
+	return nil
+}
+
+func (t choiceQuestion) MultiValue() bool {
+	return t.multiple
+}
+
+func (t choiceQuestion) ValidateAnswer(config *QuestionConfig, answer *Answer) error {
+	if !t.multiple {
+		if _, ok := config.ChoiceByID(answer.Value); !ok {
+			return errors.New("must be one of the question's options")
+		}
+		return nil
+	}
+
+	// Multiple selections are sent as a list of choice IDs.
+	selected := map[string]bool{}
+	for _, id := range answer.Values {
+		if _, ok := config.ChoiceByID(id); !ok {
+			return fmt.Errorf("%q is not one of the question's options", id)
+		}
+		if selected[id] {
+			return fmt.Errorf("option %q is selected more than once", id)
+		}
+		selected[id] = true
+	}
+	return checkSelections(config, len(selected))
+}
+
+// checkSelections checks the number of options selected in a multiple choice answer.
+func checkSelections(config *QuestionConfig, count int) error {
+	if config.MinSelections != nil && count < *config.MinSelections {
+		return fmt.Errorf("select at least %d options", *config.MinSelections)
+	}
+	if config.MaxSelections != nil && count > *config.MaxSelections {
+		return fmt.Errorf("select at most %d options", *config.MaxSelections)
+	}
+	return nil
+}
+
+// checkLength checks the length of an answer, counted in characters.
+func checkLength(config *QuestionConfig, value string) error {
+	length := utf8.RuneCountInString(value)
+	if config.MinLength != nil && length < *config.MinLength {
+		return fmt.Errorf("must be at least %d characters long", *config.MinLength)
+	}
+	if config.MaxLength != nil && length > *config.MaxLength {
+		return fmt.Errorf("must be at most %d characters long", *config.MaxLength)
+	}
+	return nil
+}
+
+func formatNumber(number float64) string {
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
+
+func validateLengths(config *QuestionConfig) error {
+	if config.MinLength != nil && *config.MinLength < 0 {
+		return errors.New("min_length must not be negative")
+	}
+	if config.MaxLength != nil && *config.MaxLength < 1 {
+		return errors.New("max_length must be at least 1")
+	}
+	if config.MinLength != nil && config.MaxLength != nil && *config.MinLength > *config.MaxLength {
+		return errors.New("min_length must not be greater than max_length")
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrNotAcceptingResponses is returned when a submission arrives outside of the
+// form's publication window or after its response cap was reached.
+var ErrNotAcceptingResponses = errors.New("form is not accepting responses")
+
+// ScheduleRequest sets the publication window and the response cap of a form.
+// A nil field removes the corresponding limit.
+type ScheduleRequest struct {
+	OpensAt      *time.Time `json:"opens_at"`
+	ClosesAt     *time.Time `json:"closes_at"`
+	MaxResponses *int       `json:"max_responses"`
+}
+
+// CheckAcceptingResponses reports why the form does not accept a submission at the given time, if it doesn't.
+// The response cap is checked separately by SubmitResponse, which needs the current count.
+func (form *Form) CheckAcceptingResponses(now time.Time) error {
+	if form.Status != FormStatusPublished && form.Status != FormStatusScheduled {
+		return fmt.Errorf("%w: form is %s", ErrNotAcceptingResponses, form.Status)
+	}
+	if form.OpensAt != nil && now.Before(*form.OpensAt) {
+		return fmt.Errorf("%w: form opens at %s", ErrNotAcceptingResponses, form.OpensAt.Format(time.RFC3339))
+	}
+	if form.ClosesAt != nil && !now.Before(*form.ClosesAt) {
+		return fmt.Errorf("%w: form closed at %s", ErrNotAcceptingResponses, form.ClosesAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// liveStatus returns the status a form being put live should have at the given time:
+// scheduled until its window opens, published afterwards.
+func (form *Form) liveStatus(now time.Time) FormStatus {
+	if form.OpensAt != nil && now.Before(*form.OpensAt) {
+		return FormStatusScheduled
+	}
+	return FormStatusPublished
+}
+
+// --- Database Functions ---
+
+// SubmitResponse saves a response if the form accepts it. The form row is locked
+// for the duration of the transaction so that concurrent submissions cannot go
+// over the response cap; the form is closed once the cap is reached.
+func SubmitResponse(response *Response) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var form Form
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&form, "id = ?", response.FormID).Error; err != nil {
+			return err
+		}
+
+		if err := form.CheckAcceptingResponses(time.Now()); err != nil {
+			return err
+		}
+
+		var count int64
+		if form.MaxResponses != nil {
+			if err := tx.Model(&Response{}).Where("form_id = ?", form.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count >= int64(*form.MaxResponses) {
+				return fmt.Errorf("%w: form reached its limit of %d responses", ErrNotAcceptingResponses, *form.MaxResponses)
+			}
+		}
+
+		if err := tx.Create(response).Error; err != nil {
+			return err
+		}
+
+		if form.MaxResponses != nil && count+1 >= int64(*form.MaxResponses) {
+			log.Printf("Form %s reached its limit of %d responses, closing it", form.ID, *form.MaxResponses)
+			return tx.Model(&form).Update("status", FormStatusClosed).Error
+		}
+		return nil
+	})
+}
+
+// ApplyFormSchedules opens scheduled forms whose window has started and closes
+// live forms whose window has ended.
+func ApplyFormSchedules(now time.Time) error {
+	opened := DB.Model(&Form{}).
+		Where("status = ? AND opens_at <= ? AND (closes_at IS NULL OR closes_at > ?)", FormStatusScheduled, now, now).
+		Update("status", FormStatusPublished)
+	if opened.Error != nil {
+		return opened.Error
+	}
+
+	closed := DB.Model(&Form{}).
+		Where("status IN ? AND closes_at <= ?", []FormStatus{FormStatusScheduled, FormStatusPublished}, now).
+		Update("status", FormStatusClosed)
+	if closed.Error != nil {
+		return closed.Error
+	}
+
+	if opened.RowsAffected > 0 || closed.RowsAffected > 0 {
+		log.Printf("Form scheduler: opened %d and closed %d forms", opened.RowsAffected, closed.RowsAffected)
+	}
+	return nil
+}
+
+// StartFormScheduler applies the form schedules every interval in the background.
+func StartFormScheduler(interval time.Duration) {
+	if interval <= 0 {
+		log.Println("Form scheduler disabled (SCHEDULER_INTERVAL is not positive)")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			if err := ApplyFormSchedules(now); err != nil {
+				log.Printf("Error applying form schedules: %v", err)
+			}
+		}
+	}()
+	log.Printf("Form scheduler started, running every %s", interval)
+}
+
+// --- Handlers ---
+
+// setScheduleHandler handles PUT /forms/:formId/schedule requests.
+func setScheduleHandler(c *gin.Context) {
+	formID := c.Param("formId")
+
+	var scheduleRequest ScheduleRequest
+	if err := c.ShouldBindJSON(&scheduleRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or incomplete JSON request: " + err.Error()})
+		return
+	}
+
+	if scheduleRequest.OpensAt != nil && scheduleRequest.ClosesAt != nil && !scheduleRequest.ClosesAt.After(*scheduleRequest.OpensAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "closes_at must be after opens_at"})
+		return
+	}
+	if scheduleRequest.MaxResponses != nil && *scheduleRequest.MaxResponses < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_responses must be at least 1"})
+		return
+	}
+
+	var form Form
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&form, "id = ?", formID).Error; err != nil {
+			return err
+		}
+
+		form.OpensAt = scheduleRequest.OpensAt
+		form.ClosesAt = scheduleRequest.ClosesAt
+		form.MaxResponses = scheduleRequest.MaxResponses
+		if form.Status == FormStatusScheduled || form.Status == FormStatusPublished {
+			form.Status = form.liveStatus(time.Now())
+		}
+
+		return tx.Model(&form).Updates(map[string]any{
+			"opens_at":      form.OpensAt,
+			"closes_at":     form.ClosesAt,
+			"max_responses": form.MaxResponses,
+			"status":        form.Status,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+			return
+		}
+		log.Printf("Error updating schedule of form %s: %v", formID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update form schedule"})
+		return
+	}
+
+	// Answer the form as GET /forms/:formId does, with its questions
+	loaded, err := GetFormByID(formID)
+	if err != nil {
+		log.Printf("Error reloading form %s: %v", formID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load form"})
+		return
+	}
+	if loaded == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, loaded)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+)
+
+// maxSections bounds the number of pages of a form.
+const maxSections = 20
+
+// --- Entities ---
+
+// Section groups the questions of a form into a page. Every question belongs to
+// exactly one section; forms built as a flat list of questions have a single one.
+type Section struct {
+	ID          uuid.UUID      `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	FormID      uuid.UUID      `json:"-" gorm:"type:uuid;index"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Position    int            `json:"position" gorm:"not null;default:0"` // Order of the section within its form, from 0
+	Questions   []Question     `json:"questions,omitempty" gorm:"foreignKey:SectionID"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+type SectionRequest struct {
+	ID          uuid.UUID         `json:"id"` // Client-supplied, used to match the request against existing sections
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Questions   []QuestionRequest `json:"questions" binding:"dive"`
+}
+
+// SectionsRequest is the body of PUT /forms/:formId/questions for multi-page forms.
+type SectionsRequest struct {
+	Sections []SectionRequest `json:"sections" binding:"dive"`
+}
+
+// SectionCheckRequest carries the answers given so far, on every page up to the one being checked.
+type SectionCheckRequest struct {
+	Answers []Answer `json:"answers" binding:"dive"`
+}
+
+// withSections preloads the sections of a form in order, with their questions in order.
+func withSections(db *gorm.DB) *gorm.DB {
+	return db.Preload("Sections", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Preload("Sections.Questions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	})
+}
+
+// collectQuestions sets the flat list of questions of a form from its sections, in page order.
+func (form *Form) collectQuestions() {
+	form.Questions = []Question{}
+	for _, section := range form.Sections {
+		form.Questions = append(form.Questions, section.Questions...)
+	}
+}
+
+// sectionRequests turns the sections or questions of a form being created into a sections update.
+// A form created as a flat list of questions gets a single section.
+func (form *Form) sectionRequests() []SectionRequest {
+	sections := form.Sections
+	if len(sections) == 0 && len(form.Questions) > 0 {
+		sections = []Section{{Questions: form.Questions}}
+	}
+
+	requests := make([]SectionRequest, 0, len(sections))
+	for _, section := range sections {
+		request := SectionRequest{ID: section.ID, Title: section.Title, Description: section.Description}
+		for _, q := range section.Questions {
+			request.Questions = append(request.Questions, QuestionRequest{
+				ID: q.ID, Text: q.Text, Type: q.Type, IsRequired: q.IsRequired, Config: q.Config, ShowIf: q.ShowIf, Jumps: q.Jumps,
+			})
+		}
+		requests = append(requests, request)
+	}
+	return requests
+}
+
+// flatSectionRequest wraps a flat list of questions into a single section,
+// keeping the first existing section of the form so its title is preserved.
+func (form *Form) flatSectionRequest(questions []QuestionRequest) []SectionRequest {
+	request := SectionRequest{Questions: questions}
+	if len(form.Sections) > 0 {
+		first := form.Sections[0]
+		request.ID, request.Title, request.Description = first.ID, first.Title, first.Description
+	}
+	return []SectionRequest{request}
+}
+
+// --- Database Functions ---
+
+// SyncSections reconciles the sections of a form, and their questions, with the
+// requested pages. Sections are matched on their ID like questions are: known ones
+// are updated, new ones inserted and the ones missing from the request soft-retired.
+func SyncSections(tx *gorm.DB, form *Form, requests []SectionRequest) ([]Section, error) {
+	if len(requests) > maxSections {
+		return nil, fmt.Errorf("%w: too many sections (max %d)", ErrInvalidQuestions, maxSections)
+	}
+
+	var existing []Section
+	if err := tx.Unscoped().Where("form_id = ?", form.ID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	existingByID := make(map[uuid.UUID]Section, len(existing))
+	for _, s := range existing {
+		existingByID[s.ID] = s
+	}
+
+	sections := make([]Section, 0, len(requests))
+	keptIDs := make([]uuid.UUID, 0, len(requests))
+	seen := make(map[uuid.UUID]bool)
+	now := time.Now()
+
+	for i, req := range requests {
+		section := Section{ID: req.ID, FormID: form.ID, CreatedAt: now}
+		_, known := existingByID[req.ID]
+
+		if req.ID != uuid.Nil {
+			if seen[req.ID] {
+				return nil, fmt.Errorf("%w: duplicate section id %s", ErrInvalidQuestions, req.ID)
+			}
+			seen[req.ID] = true
+
+			if known {
+				section = existingByID[req.ID]
+			} else {
+				var count int64
+				if err := tx.Unscoped().Model(&Section{}).Where("id = ?", req.ID).Count(&count).Error; err != nil {
+					return nil, err
+				}
+				if count > 0 {
+					return nil, fmt.Errorf("%w: section id %s belongs to another form", ErrInvalidQuestions, req.ID)
+				}
+			}
+		}
+
+		section.Title = req.Title
+		section.Description = req.Description
+		section.Position = i
+		section.UpdatedAt = now
+		section.DeletedAt = gorm.DeletedAt{} // Restores a previously retired section
+
+		if known {
+			if err := tx.Unscoped().Omit("Questions").Save(&section).Error; err != nil {
+				return nil, err
+			}
+		} else {
+			if err := tx.Omit("Questions").Create(&section).Error; err != nil {
+				return nil, err
+			}
+		}
+
+		keptIDs = append(keptIDs, section.ID)
+		sections = append(sections, section)
+	}
+
+	// Questions are synced as one list, in page order, so they can move between sections.
+	var questionRequests []QuestionRequest
+	for i, req := range requests {
+		for _, q := range req.Questions {
+			q.SectionID = sections[i].ID
+			questionRequests = append(questionRequests, q)
+		}
+	}
+	if len(questionRequests) > maxQuestions {
+		return nil, fmt.Errorf("%w: too many questions (max %d)", ErrInvalidQuestions, maxQuestions)
+	}
+
+	questions, err := SyncQuestions(tx, form, questionRequests)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sections {
+		sections[i].Questions = []Question{}
+		for _, q := range questions {
+			if q.SectionID != nil && *q.SectionID == sections[i].ID {
+				sections[i].Questions = append(sections[i].Questions, q)
+			}
+		}
+	}
+
+	retire := tx.Where("form_id = ?", form.ID)
+	if len(keptIDs) > 0 {
+		retire = retire.Where("id NOT IN ?", keptIDs)
+	}
+	if err := retire.Delete(&Section{}).Error; err != nil {
+		return nil, err
+	}
+
+	form.Sections = sections
+	form.Questions = questions
+	return sections, nil
+}
+
+// MigrateQuestionSections moves the questions of forms created before sections
+// existed into a single section per form.
+func MigrateQuestionSections() error {
+	var formIDs []uuid.UUID
+	if err := DB.Unscoped().Model(&Question{}).Where("section_id IS NULL").Distinct().Pluck("form_id", &formIDs).Error; err != nil {
+		return err
+	}
+
+	for _, formID := range formIDs {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			var section Section
+			err := tx.Where("form_id = ?", formID).Order("position").First(&section).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				section = Section{FormID: formID}
+				err = tx.Create(&section).Error
+			}
+			if err != nil {
+				return err
+			}
+			return tx.Unscoped().Model(&Question{}).
+				Where("form_id = ? AND section_id IS NULL", formID).
+				UpdateColumn("section_id", section.ID).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(formIDs) > 0 {
+		log.Printf("Moved the questions of %d forms into sections", len(formIDs))
+	}
+	return nil
+}
+
+// --- Handlers ---
+
+// bindQuestionsUpdate reads the body of a questions update: either a flat list of
+// questions, which replaces the whole form with a single page, or an object with sections.
+func bindQuestionsUpdate(c *gin.Context, form *Form) ([]SectionRequest, error) {
+	body, err := c.GetRawData()
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var questions []QuestionRequest
+		if err := binding.JSON.BindBody(body, &questions); err != nil {
+			return nil, err
+		}
+		return form.flatSectionRequest(questions), nil
+	}
+
+	var sectionsRequest SectionsRequest
+	if err := binding.JSON.BindBody(body, &sectionsRequest); err != nil {
+		return nil, err
+	}
+	return sectionsRequest.Sections, nil
+}
+
+// validateSectionHandler handles POST /forms/:formId/sections/:sectionId/validate requests.
+// It checks the answers to one page, so the respondent can fix them before moving on,
+// and tells which section comes next given the answers so far.
+func validateSectionHandler(c *gin.Context) {
+	sectionID, err := uuid.Parse(c.Param("sectionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid section ID format"})
+		return
+	}
+
+	form := currentForm(c) // Loaded and authorized by authorizeForm
+
+	found := false
+	for _, section := range form.Sections {
+		found = found || section.ID == sectionID
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Section not found"})
+		return
+	}
+
+	var checkRequest SectionCheckRequest
+	if err := c.ShouldBindJSON(&checkRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or incomplete JSON request: " + err.Error()})
+		return
+	}
+
+	fieldErrors, next := ValidateSection(form, sectionID, checkRequest.Answers)
+	if len(fieldErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Some answers are not valid", "fields": fieldErrors})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"valid": true, "next_section_id": next})
+}
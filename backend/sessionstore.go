@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// sessionCookieName is the name of the cookie carrying the session ID.
+const sessionCookieName = "gform_session"
+
+// --- Entities ---
+
+// UserSession is a session kept server side. The cookie only carries its signed
+// and encrypted ID, so a session ends as soon as its row is deleted.
+type UserSession struct {
+	ID        uuid.UUID  `json:"id" gorm:"primaryKey;type:uuid"`
+	UserID    *uuid.UUID `json:"-" gorm:"type:uuid;index"` // The signed in user, nil for anonymous sessions
+	Data      []byte     `json:"-"`                        // Session values, gob encoded
+	UserAgent string     `json:"user_agent"`
+	IPAddress string     `json:"ip_address"`
+	Current   bool       `json:"current" gorm:"-"` // Set when listing: the session of the request
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"index"`
+}
+
+// --- Store ---
+
+// DBStore is a sessions.Store keeping sessions in the user_sessions table.
+type DBStore struct {
+	Codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// NewDBStore returns a store whose cookies are encoded with the given key pairs, as
+// securecookie.CodecsFromPairs takes them: the first pair encodes, every pair decodes.
+func NewDBStore(lifetime time.Duration, keyPairs ...[]byte) *DBStore {
+	store := &DBStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+	store.maxAge(int(lifetime.Seconds()))
+	return store
+}
+
+// SessionKeyPairs builds the cookie key pairs from the configured keys. Keys are listed
+// newest first: new cookies use the first pair and the others are still accepted, so
+// keys can be rotated without signing everybody out. Encryption keys are hashed to
+// AES-256 keys; a signing key without an encryption key gives signed-only cookies.
+func SessionKeyPairs(signingKeys, encryptionKeys []string) [][]byte {
+	var pairs [][]byte
+	for i, signingKey := range signingKeys {
+		if signingKey == "" {
+			continue
+		}
+		var encryptionKey []byte
+		if i < len(encryptionKeys) && encryptionKeys[i] != "" {
+			sum := sha256.Sum256([]byte(encryptionKeys[i]))
+			encryptionKey = sum[:]
+		}
+		pairs = append(pairs, []byte(signingKey), encryptionKey)
+	}
+	return pairs
+}
+
+// Options implements sessions.Store.
+func (store *DBStore) Options(options sessions.Options) {
+	store.options = options.ToGorillaOptions()
+	store.maxAge(store.options.MaxAge)
+}
+
+func (store *DBStore) maxAge(age int) {
+	store.options.MaxAge = age
+	for _, codec := range store.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Get returns the session of the request, loading it at most once per request.
+func (store *DBStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(store, name)
+}
+
+// New loads the session named by the request's cookie. Cookies that can't be decoded,
+// for instance because their key was rotated out, and sessions that expired or were
+// revoked give a new, empty session rather than an error.
+func (store *DBStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(store, name)
+	options := *store.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, store.Codecs...); err != nil {
+		return session, nil
+	}
+
+	var record UserSession
+	if err := DB.Limit(1).Find(&record, "id = ? AND expires_at > ?", id, time.Now()).Error; err != nil {
+		return session, err
+	}
+	if record.ID == uuid.Nil {
+		return session, nil
+	}
+	if err := (securecookie.GobEncoder{}).Deserialize(record.Data, &session.Values); err != nil {
+		return session, err
+	}
+
+	session.ID = record.ID.String()
+	session.IsNew = false
+	return session, nil
+}
+
+// Save writes the session and its cookie. A session whose MaxAge is negative is
+// deleted. A session changing hands, on sign in or sign out, gets a new ID so an ID
+// known before signing in can't be used afterwards.
+func (store *DBStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := DB.Delete(&UserSession{}, "id = ?", session.ID).Error; err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	var userID *uuid.UUID
+	if value, ok := session.Values[sessionUserIDKey].(string); ok {
+		if id, err := uuid.Parse(value); err == nil {
+			userID = &id
+		}
+	}
+
+	var record UserSession
+	if session.ID != "" {
+		if err := DB.Limit(1).Find(&record, "id = ?", session.ID).Error; err != nil {
+			return err
+		}
+		if record.ID != uuid.Nil && !sameUser(record.UserID, userID) {
+			if err := DB.Delete(&record).Error; err != nil {
+				return err
+			}
+			record = UserSession{}
+		}
+	}
+
+	data, err := (securecookie.GobEncoder{}).Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if record.ID == uuid.Nil {
+		record = UserSession{ID: uuid.New(), CreatedAt: now}
+	}
+	record.UserID = userID
+	record.Data = data
+	record.UserAgent = r.UserAgent()
+	record.IPAddress = clientIP(r)
+	record.UpdatedAt = now
+	record.ExpiresAt = now.Add(time.Duration(session.Options.MaxAge) * time.Second)
+	if err := DB.Save(&record).Error; err != nil {
+		return err
+	}
+	session.ID = record.ID.String()
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, store.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func sameUser(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// clientIPKey is the request context key of the address resolved by resolveClientIP.
+type clientIPKey struct{}
+
+// resolveClientIP keeps the address of the client, as gin resolves it through the
+// TRUSTED_PROXIES, in the request context, for the session store which only sees the
+// request. It must run before the sessions middleware.
+func resolveClientIP(c *gin.Context) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP()))
+	c.Next()
+}
+
+// clientIP returns the address the request came from, without the port: the one
+// resolved by resolveClientIP, or the peer of the connection.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// NewSessionStore returns the session store configured in AppConfig. Outside of
+// production, missing keys are replaced by random ones, which sign everybody out on restart.
+func NewSessionStore() *DBStore {
+	if AppConfig.SessionLifetime <= 0 {
+		log.Fatal("SESSION_LIFETIME must be positive")
+	}
+
+	keyPairs := SessionKeyPairs(AppConfig.SessionSigningKeys, AppConfig.SessionEncryptionKeys)
+	if len(keyPairs) == 0 {
+		if AppConfig.AppEnv == "production" || AppConfig.AppEnv == "prod" {
+			log.Fatal("SESSION_SIGNING_KEYS must be set in production")
+		}
+		log.Println("WARNING: SESSION_SIGNING_KEYS is not set, using random session keys. Sessions won't survive a restart.")
+		keyPairs = [][]byte{securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32)}
+	}
+
+	store := NewDBStore(AppConfig.SessionLifetime, keyPairs...)
+	store.options.Secure = AppConfig.AppEnv == "production" || AppConfig.AppEnv == "prod"
+	return store
+}
+
+// --- Database Functions ---
+
+// GetUserSessions returns the sessions of a user that have not expired, most recent first.
+func GetUserSessions(userID uuid.UUID) ([]UserSession, error) {
+	var userSessions []UserSession
+	err := DB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("updated_at DESC").Find(&userSessions).Error
+	return userSessions, err
+}
+
+// RevokeUserSession ends one session of a user.
+func RevokeUserSession(userID, sessionID uuid.UUID) error {
+	result := DB.Where("user_id = ?", userID).Delete(&UserSession{}, "id = ?", sessionID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions ends every session of a user except the one given, if any.
+// It returns the number of sessions ended.
+func RevokeUserSessions(userID uuid.UUID, except *uuid.UUID) (int64, error) {
+	query := DB.Where("user_id = ?", userID)
+	if except != nil {
+		query = query.Where("id <> ?", *except)
+	}
+	result := query.Delete(&UserSession{})
+	return result.RowsAffected, result.Error
+}
+
+// PurgeExpiredSessions deletes the sessions that expired before now.
+func PurgeExpiredSessions(now time.Time) error {
+	result := DB.Where("expires_at <= ?", now).Delete(&UserSession{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Session purge: deleted %d expired sessions", result.RowsAffected)
+	}
+	return nil
+}
+
+// StartSessionPurger deletes expired sessions every interval in the background.
+func StartSessionPurger(interval time.Duration) {
+	if interval <= 0 {
+		log.Println("Session purge disabled (SESSION_PURGE_INTERVAL is not positive)")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			if err := PurgeExpiredSessions(now); err != nil {
+				log.Printf("Error purging expired sessions: %v", err)
+			}
+		}
+	}()
+	log.Printf("Session purge started, deleting expired sessions every %s", interval)
+}
+
+// --- Handlers ---
+
+// currentSessionID returns the ID of the request's session, or nil if it has not been saved yet.
+func currentSessionID(c *gin.Context) *uuid.UUID {
+	id, err := uuid.Parse(sessions.Default(c).ID())
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// listSessionsHandler handles GET /api/account/sessions requests.
+func listSessionsHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	userSessions, err := GetUserSessions(user.ID)
+	if err != nil {
+		log.Printf("Error retrieving sessions of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving sessions"})
+		return
+	}
+
+	if current := currentSessionID(c); current != nil {
+		for i := range userSessions {
+			userSessions[i].Current = userSessions[i].ID == *current
+		}
+	}
+	c.JSON(http.StatusOK, userSessions)
+}
+
+// revokeSessionHandler handles DELETE /api/account/sessions/:sessionId requests.
+// Revoking the current session signs the user out.
+func revokeSessionHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	sessionID, err := uuid.Parse(c.Param("sessionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID format"})
+		return
+	}
+
+	if err := RevokeUserSession(user.ID, sessionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		log.Printf("Error revoking session %s: %v", sessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke session"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// revokeSessionsHandler handles DELETE /api/account/sessions requests. It signs the user
+// out of every other device; pass ?include_current=true to sign out of this one too.
+func revokeSessionsHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	except := currentSessionID(c)
+	if c.Query("include_current") == "true" {
+		except = nil
+	}
+
+	revoked, err := RevokeUserSessions(user.ID, except)
+	if err != nil {
+		log.Printf("Error revoking sessions of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
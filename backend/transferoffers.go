@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var ErrSelfTransfer = errors.New("the forms can't be offered to their owner")
+
+// --- Entities ---
+
+// FormTransferOffer offers the forms of a user to another account, to be transferred when
+// the user deletes their account. The offer is made to an email, so making one tells
+// nothing about which emails have an account, and the forms only move once the account
+// using the email accepted it. A user has one offer at most.
+type FormTransferOffer struct {
+	ID           uuid.UUID  `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	FromUserID   uuid.UUID  `json:"-" gorm:"type:uuid;uniqueIndex"`
+	ToEmail      string     `json:"to_email" gorm:"index:idx_form_transfer_offers_to_email_lower,expression:lower(to_email)"`
+	ToUserID     *uuid.UUID `json:"-" gorm:"type:uuid;index"` // The account that accepted the offer
+	AcceptedAt   *time.Time `json:"accepted_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	FromUsername string     `json:"from_username,omitempty" gorm:"->;-:migration"` // Read with the received offers
+}
+
+type FormTransferOfferRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// FormTransferOffers are the offers a user made and received.
+type FormTransferOffers struct {
+	Sent     *FormTransferOffer  `json:"sent"`
+	Received []FormTransferOffer `json:"received"`
+}
+
+// --- Database Functions ---
+
+// OfferFormTransfer offers the forms of a user to the account using an email, replacing
+// the offer the user made before. The account, if there is one, is told by email.
+func OfferFormTransfer(user *User, email string) (*FormTransferOffer, error) {
+	email = strings.TrimSpace(email)
+	if strings.EqualFold(email, user.Email) {
+		return nil, ErrSelfTransfer
+	}
+
+	offer := FormTransferOffer{FromUserID: user.ID, ToEmail: email}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("from_user_id = ?", user.ID).Delete(&FormTransferOffer{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&offer).Error; err != nil {
+			return err
+		}
+
+		var recipient User
+		if err := tx.Limit(1).Find(&recipient, "lower(email) = lower(?)", email).Error; err != nil {
+			return err
+		}
+		if recipient.ID == uuid.Nil {
+			return nil
+		}
+		return QueueEmail(tx, MailNotification, recipient.Email, NotificationMail{
+			Username: recipient.Username,
+			Title:    "GForms forms were offered to you",
+			Message:  fmt.Sprintf("%s offered to transfer their forms to you, with the responses they collected, when they delete their account. Sign in to accept or decline the offer.", user.Username),
+			Link:     frontendLink("/account/login"),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &offer, nil
+}
+
+// GetFormTransferOffers returns the offer a user made, if any, and the offers made to them.
+func GetFormTransferOffers(user *User) (*FormTransferOffers, error) {
+	offers := FormTransferOffers{Received: []FormTransferOffer{}}
+
+	var sent []FormTransferOffer
+	if err := DB.Where("from_user_id = ?", user.ID).Limit(1).Find(&sent).Error; err != nil {
+		return nil, err
+	}
+	if len(sent) > 0 {
+		offers.Sent = &sent[0]
+	}
+
+	err := DB.Table("form_transfer_offers").
+		Select("form_transfer_offers.*, users.username AS from_username").
+		Joins("JOIN users ON users.id = form_transfer_offers.from_user_id").
+		Where("form_transfer_offers.to_user_id = ? OR (form_transfer_offers.to_user_id IS NULL AND lower(form_transfer_offers.to_email) = lower(?))", user.ID, user.Email).
+		Order("form_transfer_offers.created_at DESC").
+		Scan(&offers.Received).Error
+	if err != nil {
+		return nil, err
+	}
+	return &offers, nil
+}
+
+// AcceptFormTransfer accepts an offer made to a user. The sender is told by email.
+func AcceptFormTransfer(user *User, offerID uuid.UUID) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&FormTransferOffer{}).
+			Where("id = ? AND to_user_id IS NULL AND lower(to_email) = lower(?)", offerID, user.Email).
+			Updates(map[string]any{"to_user_id": user.ID, "accepted_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var sender User
+		err := tx.Joins("JOIN form_transfer_offers ON form_transfer_offers.from_user_id = users.id").
+			Where("form_transfer_offers.id = ?", offerID).
+			Limit(1).Find(&sender).Error
+		if err != nil {
+			return err
+		}
+		return QueueEmail(tx, MailNotification, sender.Email, NotificationMail{
+			Username: sender.Username,
+			Title:    "Your forms transfer offer was accepted",
+			Message:  fmt.Sprintf("%s accepted your forms. They will be transferred to them if you delete your account choosing to transfer your forms.", user.Username),
+		})
+	})
+}
+
+// DeleteFormTransferOffer withdraws an offer a user made, or declines an offer made to them.
+func DeleteFormTransferOffer(user *User, offerID uuid.UUID) error {
+	result := DB.Where("from_user_id = ? OR to_user_id = ? OR (to_user_id IS NULL AND lower(to_email) = lower(?))", user.ID, user.ID, user.Email).
+		Delete(&FormTransferOffer{}, "id = ?", offerID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// acceptedTransferRecipient returns the account that accepted the offer of a user, locking
+// the offer so it can't be withdrawn while the forms are transferred. It returns
+// ErrTransferTarget if no account accepted it.
+func acceptedTransferRecipient(tx *gorm.DB, userID uuid.UUID) (*User, error) {
+	var offer FormTransferOffer
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Limit(1).
+		Find(&offer, "from_user_id = ? AND to_user_id IS NOT NULL", userID).Error
+	if err != nil {
+		return nil, err
+	}
+	if offer.ID == uuid.Nil {
+		return nil, ErrTransferTarget
+	}
+
+	var recipient User
+	if err := tx.Limit(1).Find(&recipient, "id = ?", *offer.ToUserID).Error; err != nil {
+		return nil, err
+	}
+	if recipient.ID == uuid.Nil {
+		return nil, ErrTransferTarget
+	}
+	return &recipient, nil
+}
+
+// --- Handlers ---
+
+// listFormTransferOffersHandler handles GET /api/account/transfer-offers requests.
+func listFormTransferOffersHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	offers, err := GetFormTransferOffers(user)
+	if err != nil {
+		log.Printf("Error retrieving forms transfer offers of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving transfer offers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, offers)
+}
+
+// createFormTransferOfferHandler handles POST /api/account/transfer-offers requests. The
+// answer is the same whether or not an account uses the email.
+func createFormTransferOfferHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	var offerRequest FormTransferOfferRequest
+	if err := c.ShouldBindJSON(&offerRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or incomplete JSON request: " + err.Error()})
+		return
+	}
+
+	offer, err := OfferFormTransfer(user, offerRequest.Email)
+	if err != nil {
+		if errors.Is(err, ErrSelfTransfer) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You can't transfer your forms to yourself"})
+			return
+		}
+		log.Printf("Error offering the forms of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not make the transfer offer"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, offer)
+}
+
+// acceptFormTransferOfferHandler handles POST /api/account/transfer-offers/:offerId/accept requests.
+func acceptFormTransferOfferHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	offerID, err := uuid.Parse(c.Param("offerId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offer ID format"})
+		return
+	}
+
+	if err := AcceptFormTransfer(user, offerID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transfer offer not found"})
+			return
+		}
+		log.Printf("Error accepting transfer offer %s: %v", offerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not accept the transfer offer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer offer accepted"})
+}
+
+// deleteFormTransferOfferHandler handles DELETE /api/account/transfer-offers/:offerId requests,
+// withdrawing an offer the user made or declining one made to them.
+func deleteFormTransferOfferHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	offerID, err := uuid.Parse(c.Param("offerId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offer ID format"})
+		return
+	}
+
+	if err := DeleteFormTransferOffer(user, offerID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transfer offer not found"})
+			return
+		}
+		log.Printf("Error deleting transfer offer %s: %v", offerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete the transfer offer"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ErrParentTrashed is returned when restoring a response whose form is itself in the trash.
+var ErrParentTrashed = errors.New("the form of this response is in the trash, restore the form first")
+
+// ErrResponseLimitReached is returned when restoring a response would take its form over its response cap.
+var ErrResponseLimitReached = errors.New("cannot restore the response")
+
+// TrashedForm is a soft-deleted form as listed in the trash.
+type TrashedForm struct {
+	Form
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at"` // When the form will be deleted for good, if a retention period is set
+}
+
+// TrashedResponse is a soft-deleted response as listed in the trash.
+type TrashedResponse struct {
+	Response
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at"`
+}
+
+// purgeAt returns when a record deleted at the given time will be purged, or nil if it never will.
+func purgeAt(deletedAt time.Time) *time.Time {
+	if AppConfig.TrashRetention <= 0 {
+		return nil
+	}
+	at := deletedAt.Add(AppConfig.TrashRetention)
+	return &at
+}
+
+// --- Database Functions ---
+
+// GetTrashedForms retrieves the soft-deleted forms of a user, most recently deleted first.
+func GetTrashedForms(userID string) ([]TrashedForm, error) {
+	var forms []Form
+	result := DB.Unscoped().
+		Where("creator_user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at desc").Find(&forms)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	trashed := make([]TrashedForm, 0, len(forms))
+	for _, form := range forms {
+		trashed = append(trashed, TrashedForm{Form: form, DeletedAt: form.DeletedAt.Time, PurgeAt: purgeAt(form.DeletedAt.Time)})
+	}
+	return trashed, nil
+}
+
+// GetTrashedResponses retrieves the responses that were deleted on their own from
+// the live forms of a user. Responses deleted with their form are restored with it.
+func GetTrashedResponses(userID string) ([]TrashedResponse, error) {
+	var responses []Response
+	result := DB.Unscoped().
+		Preload("Answers", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Joins("JOIN forms ON forms.id = responses.form_id").
+		Where("forms.creator_user_id = ? AND forms.deleted_at IS NULL AND responses.deleted_at IS NOT NULL", userID).
+		Order("responses.deleted_at desc").Find(&responses)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	trashed := make([]TrashedResponse, 0, len(responses))
+	for _, response := range responses {
+		trashed = append(trashed, TrashedResponse{Response: response, DeletedAt: response.DeletedAt.Time, PurgeAt: purgeAt(response.DeletedAt.Time)})
+	}
+	return trashed, nil
+}
+
+// getTrashedForm retrieves a soft-deleted form owned by the user.
+func getTrashedForm(tx *gorm.DB, formID string, userID uuid.UUID) (*Form, error) {
+	var form Form
+	err := tx.Unscoped().
+		First(&form, "id = ? AND creator_user_id = ? AND deleted_at IS NOT NULL", formID, userID.String()).Error
+	if err != nil {
+		return nil, err
+	}
+	return &form, nil
+}
+
+// getTrashedResponse retrieves a soft-deleted response to a form owned by the user.
+func getTrashedResponse(tx *gorm.DB, responseID string, userID uuid.UUID) (*Response, error) {
+	var response Response
+	err := tx.Unscoped().
+		Joins("JOIN forms ON forms.id = responses.form_id").
+		Where("forms.creator_user_id = ? AND responses.deleted_at IS NOT NULL", userID.String()).
+		First(&response, "responses.id = ?", responseID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// RestoreForm takes a form out of the trash, with the responses that were deleted with it.
+func RestoreForm(formID string, userID uuid.UUID) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		form, err := getTrashedForm(tx, formID, userID)
+		if err != nil {
+			return err
+		}
+		deletedAt := form.DeletedAt.Time
+
+		responseIDs := tx.Unscoped().Model(&Response{}).Select("id").Where("form_id = ? AND deleted_at = ?", form.ID, deletedAt)
+		if err := tx.Unscoped().Model(&Answer{}).
+			Where("response_id IN (?) AND deleted_at = ?", responseIDs, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&Response{}).
+			Where("form_id = ? AND deleted_at = ?", form.ID, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(form).Update("deleted_at", nil).Error
+	})
+}
+
+// RestoreResponse takes a response out of the trash. Its form must not be in the trash.
+func RestoreResponse(responseID string, userID uuid.UUID) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		response, err := getTrashedResponse(tx, responseID, userID)
+		if err != nil {
+			return err
+		}
+
+		// Lock the form as SubmitResponse does, so restores and submissions
+		// can't go over the response cap together.
+		var form Form
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&form, "id = ?", response.FormID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrParentTrashed
+			}
+			return err
+		}
+
+		var count int64
+		if form.MaxResponses != nil {
+			if err := tx.Model(&Response{}).Where("form_id = ?", form.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count >= int64(*form.MaxResponses) {
+				return fmt.Errorf("%w: form reached its limit of %d responses", ErrResponseLimitReached, *form.MaxResponses)
+			}
+		}
+
+		if err := tx.Unscoped().Model(&Answer{}).
+			Where("response_id = ? AND deleted_at = ?", response.ID, response.DeletedAt.Time).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(response).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+
+		if form.MaxResponses != nil && count+1 >= int64(*form.MaxResponses) &&
+			(form.Status == FormStatusPublished || form.Status == FormStatusScheduled) {
+			log.Printf("Form %s reached its limit of %d responses, closing it", form.ID, *form.MaxResponses)
+			return tx.Model(&form).Update("status", FormStatusClosed).Error
+		}
+		return nil
+	})
+}
+
+// purgeForm permanently deletes a form with everything that belongs to it.
+func purgeForm(tx *gorm.DB, formID uuid.UUID) error {
+	responseIDs := tx.Unscoped().Model(&Response{}).Select("id").Where("form_id = ?", formID)
+	if err := tx.Unscoped().Where("response_id IN (?)", responseIDs).Delete(&Answer{}).Error; err != nil {
+		return err
+	}
+	// Questions before sections, which they reference.
+	for _, model := range []any{&Response{}, &Question{}, &Section{}, &FormVersion{}} {
+		if err := tx.Unscoped().Where("form_id = ?", formID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Delete(&Form{}, "id = ?", formID).Error
+}
+
+// purgeResponse permanently deletes a response and its answers.
+func purgeResponse(tx *gorm.DB, responseID uuid.UUID) error {
+	if err := tx.Unscoped().Where("response_id = ?", responseID).Delete(&Answer{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&Response{}, "id = ?", responseID).Error
+}
+
+// PurgeForm permanently deletes a form of the user's trash.
+func PurgeForm(formID string, userID uuid.UUID) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		form, err := getTrashedForm(tx, formID, userID)
+		if err != nil {
+			return err
+		}
+		return purgeForm(tx, form.ID)
+	})
+}
+
+// PurgeResponse permanently deletes a response of the user's trash.
+func PurgeResponse(responseID string, userID uuid.UUID) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		response, err := getTrashedResponse(tx, responseID, userID)
+		if err != nil {
+			return err
+		}
+		return purgeResponse(tx, response.ID)
+	})
+}
+
+// PurgeExpiredTrash permanently deletes the forms and responses that were deleted before the cutoff.
+func PurgeExpiredTrash(cutoff time.Time) error {
+	var formIDs []uuid.UUID
+	if err := DB.Unscoped().Model(&Form{}).Where("deleted_at < ?", cutoff).Pluck("id", &formIDs).Error; err != nil {
+		return err
+	}
+	for _, formID := range formIDs {
+		if err := DB.Transaction(func(tx *gorm.DB) error { return purgeForm(tx, formID) }); err != nil {
+			return err
+		}
+	}
+
+	var responseIDs []uuid.UUID
+	if err := DB.Unscoped().Model(&Response{}).Where("deleted_at < ?", cutoff).Pluck("id", &responseIDs).Error; err != nil {
+		return err
+	}
+	for _, responseID := range responseIDs {
+		if err := DB.Transaction(func(tx *gorm.DB) error { return purgeResponse(tx, responseID) }); err != nil {
+			return err
+		}
+	}
+
+	if len(formIDs) > 0 || len(responseIDs) > 0 {
+		log.Printf("Trash purge: deleted %d forms and %d responses for good", len(formIDs), len(responseIDs))
+	}
+	return nil
+}
+
+// StartTrashPurger purges the trash every interval in the background,
+// deleting for good what was deleted more than retention ago.
+func StartTrashPurger(retention, interval time.Duration) {
+	if retention <= 0 || interval <= 0 {
+		log.Println("Trash purge disabled (TRASH_RETENTION or TRASH_PURGE_INTERVAL is not positive)")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			if err := PurgeExpiredTrash(now.Add(-retention)); err != nil {
+				log.Printf("Error purging the trash: %v", err)
+			}
+		}
+	}()
+	log.Printf("Trash purge started, deleting records trashed for more than %s every %s", retention, interval)
+}
+
+// --- Handlers ---
+
+// listTrashedFormsHandler handles GET /trash/forms requests.
+func listTrashedFormsHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	forms, err := GetTrashedForms(user.ID.String())
+	if err != nil {
+		log.Printf("Error retrieving trashed forms: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, forms)
+}
+
+// listTrashedResponsesHandler handles GET /trash/responses requests.
+func listTrashedResponsesHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	responses, err := GetTrashedResponses(user.ID.String())
+	if err != nil {
+		log.Printf("Error retrieving trashed responses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses)
+}
+
+// trashActionHandler returns a handler for the restore and purge routes of the trash, given
+// the route parameter holding the ID and the action ("restore" or "purge") to apply.
+func trashActionHandler(param, action string, apply func(id string, userID uuid.UUID) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		id := c.Param(param)
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + strings.TrimSuffix(param, "Id") + " ID format"}) // formId: "Invalid form ID format"
+			return
+		}
+
+		if err := apply(id, user.ID); err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "Not found in the trash"})
+			case errors.Is(err, ErrParentTrashed), errors.Is(err, ErrResponseLimitReached):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				log.Printf("Error applying %s to %s: %v", action, id, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not " + action})
+			}
+			return
+		}
+
+		log.Printf("Trash: %s %s by UserID=%s", action, id, user.ID)
+		if action == "purge" {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Restored successfully"})
+	}
+}
+
+// deleteResponseHandler handles DELETE /forms/:formId/responses/:responseId requests.
+// The response goes to the trash.
+func deleteResponseHandler(c *gin.Context) {
+	user := currentUser(c)
+	form := currentForm(c) // Loaded by authorizeForm, which checked the user owns it
+	responseID := c.Param("responseId")
+	if _, err := uuid.Parse(responseID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid response ID format"})
+		return
+	}
+
+	response, err := GetResponseByID(responseID)
+	if err != nil {
+		log.Printf("Error retrieving response %s: %v", responseID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving response"})
+		return
+	}
+	if response == nil || response.FormID != form.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Response not found"})
+		return
+	}
+
+	if err := DeleteResponse(responseID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Response not found"})
+			return
+		}
+		log.Printf("Error deleting response %s: %v", responseID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete response"})
+		return
+	}
+
+	log.Printf("Response deleted: ID=%s by UserID=%s", responseID, user.ID)
+	c.Status(http.StatusNoContent)
+}
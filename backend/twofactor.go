@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// Session values of a partial session: the password was checked, the second factor not yet.
+// sessionUser ignores them, so a partial session is not signed in.
+const (
+	sessionPendingUserIDKey   = "pending_user_id"
+	sessionPendingSinceKey    = "pending_since"    // Unix time the password was checked
+	sessionPendingAttemptsKey = "pending_attempts" // Wrong codes entered so far
+)
+
+const (
+	totpPeriod           = 30 // Seconds a code is valid for
+	totpSkew             = 1  // Codes of the periods just before and after are accepted, for clock drift
+	recoveryCodeCount    = 10
+	twoFactorPendingTTL  = 5 * time.Minute // How long the code can be entered after the password
+	twoFactorMaxAttempts = 5               // Wrong codes accepted before the password must be entered again
+)
+
+var totpDigits = otp.DigitsSix
+
+// Errors returned by the two-factor functions.
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrInvalidCode         = errors.New("invalid code")
+)
+
+// --- Entities ---
+
+// TwoFactor is the TOTP secret of a user. It only protects sign ins once confirmed, by
+// entering a first code: until then it is an enrollment in progress.
+type TwoFactor struct {
+	UserID      uuid.UUID  `json:"-" gorm:"primaryKey;type:uuid"`
+	Secret      string     `json:"-"`          // Base32, as shown to authenticator apps
+	ConfirmedAt *time.Time `json:"enabled_at"` // Two-factor authentication is enabled when set
+	LastStep    int64      `json:"-"`          // Time step of the last code accepted, so a code can't be used twice
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// RecoveryCode signs in once in place of a TOTP code, when the authenticator is lost.
+// Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID    uuid.UUID `json:"-" gorm:"type:uuid;index"`
+	CodeHash  string    `json:"-" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"` // A TOTP code, or a recovery code where accepted
+}
+
+// TwoFactorEnrollment is the answer to an enrollment: the secret to add to an authenticator app.
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI, what the QR code encodes
+	QRCode          string `json:"qr_code"`          // PNG data URL of the QR code
+}
+
+// Enabled reports whether the secret protects sign ins.
+func (twoFactor *TwoFactor) Enabled() bool {
+	return twoFactor != nil && twoFactor.ConfirmedAt != nil
+}
+
+// matchTOTP returns the time step of the TOTP code if it is valid for the secret at now.
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits.Length() {
+		return 0, false
+	}
+	step := now.Unix() / totpPeriod
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix((step+offset)*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    totpDigits,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step + offset, true
+		}
+	}
+	return 0, false
+}
+
+// matchCode returns the time step of the TOTP code if it is valid at now and newer than
+// the last code accepted: a code can't be used twice, even within its period.
+func (twoFactor *TwoFactor) matchCode(code string, now time.Time) (int64, bool) {
+	step, ok := matchTOTP(twoFactor.Secret, code, now)
+	if !ok || step <= twoFactor.LastStep {
+		return 0, false
+	}
+	return step, true
+}
+
+// newRecoveryCodes returns recovery codes to hand to the user, formatted as xxxxx-xxxxx.
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode makes recovery codes match however they were typed.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
+
+// --- Database Functions ---
+
+// GetTwoFactor returns the TOTP secret of a user, or nil if they have none.
+func GetTwoFactor(tx *gorm.DB, userID uuid.UUID) (*TwoFactor, error) {
+	var twoFactor TwoFactor
+	if err := tx.Limit(1).Find(&twoFactor, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if twoFactor.UserID == uuid.Nil {
+		return nil, nil
+	}
+	return &twoFactor, nil
+}
+
+// TwoFactorEnabled reports whether the sign ins of a user need a second factor.
+func TwoFactorEnabled(userID uuid.UUID) (bool, error) {
+	twoFactor, err := GetTwoFactor(DB, userID)
+	if err != nil {
+		return false, err
+	}
+	return twoFactor.Enabled(), nil
+}
+
+// EnrollTwoFactor generates a new TOTP secret for a user, replacing an enrollment that
+// wasn't confirmed. It fails if two-factor authentication is already enabled.
+func EnrollTwoFactor(user *User) (*TwoFactorEnrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      "GForms",
+		AccountName: user.Email,
+		Period:      totpPeriod,
+		Digits:      totpDigits,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		existing, err := GetTwoFactor(tx.Clauses(clause.Locking{Strength: "UPDATE"}), user.ID)
+		if err != nil {
+			return err
+		}
+		if existing.Enabled() {
+			return ErrTwoFactorEnabled
+		}
+		return tx.Save(&TwoFactor{UserID: user.ID, Secret: key.Secret()}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment := &TwoFactorEnrollment{Secret: key.Secret(), ProvisioningURI: key.URL()}
+	if image, err := key.Image(256, 256); err == nil {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image); err == nil {
+			enrollment.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+	}
+	return enrollment, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the user enters a code of the
+// secret they enrolled, and returns their recovery codes.
+func ConfirmTwoFactor(user *User, code string) ([]string, error) {
+	var codes []string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := GetTwoFactor(tx.Clauses(clause.Locking{Strength: "UPDATE"}), user.ID)
+		if err != nil {
+			return err
+		}
+		if twoFactor == nil {
+			return ErrTwoFactorNotEnabled
+		}
+		if twoFactor.Enabled() {
+			return ErrTwoFactorEnabled
+		}
+
+		step, ok := matchTOTP(twoFactor.Secret, code, time.Now())
+		if !ok {
+			return ErrInvalidCode
+		}
+		now := time.Now()
+		twoFactor.ConfirmedAt = &now
+		twoFactor.LastStep = step
+		if err := tx.Save(twoFactor).Error; err != nil {
+			return err
+		}
+
+		if codes, err = replaceRecoveryCodes(tx, user.ID); err != nil {
+			return err
+		}
+
+		return QueueEmail(tx, MailNotification, user.Email, NotificationMail{
+			Username: user.Username,
+			Title:    "Two-factor authentication is on",
+			Message:  "Signing in to your GForms account now needs a code of your authenticator app. If you didn't turn it on, reset your password and check your account.",
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of a user and returns new ones.
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	records := make([]RecoveryCode, len(codes))
+	for i, code := range codes {
+		records[i] = RecoveryCode{UserID: userID, CodeHash: hashToken(code)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkSecondFactor checks a TOTP code, or a recovery code if allowed, of a user with
+// two-factor authentication enabled, using it up. It runs inside a transaction.
+func checkSecondFactor(tx *gorm.DB, userID uuid.UUID, code string, allowRecovery bool) error {
+	twoFactor, err := GetTwoFactor(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID)
+	if err != nil {
+		return err
+	}
+	if !twoFactor.Enabled() {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, ok := twoFactor.matchCode(code, time.Now()); ok {
+		return tx.Model(twoFactor).UpdateColumn("last_step", step).Error
+	}
+
+	if allowRecovery {
+		result := tx.Where("user_id = ? AND code_hash = ?", userID, hashToken(normalizeRecoveryCode(code))).Delete(&RecoveryCode{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("User %s signed in with a recovery code", userID)
+			return nil
+		}
+	}
+	return ErrInvalidCode
+}
+
+// VerifySecondFactor checks the code entered to complete a sign in: a TOTP code or a recovery code.
+func VerifySecondFactor(userID uuid.UUID, code string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return checkSecondFactor(tx, userID, code, true)
+	})
+}
+
+// DisableTwoFactor turns two-factor authentication off, after checking a code: a lost
+// authenticator can be replaced by signing in with a recovery code, then disabling it
+// with another one.
+func DisableTwoFactor(user *User, code string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkSecondFactor(tx, user.ID, code, true); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&TwoFactor{}).Error; err != nil {
+			return err
+		}
+
+		return QueueEmail(tx, MailNotification, user.Email, NotificationMail{
+			Username:  user.Username,
+			Title:     "Two-factor authentication is off",
+			Message:   "Signing in to your GForms account no longer needs a code of your authenticator app. If you didn't turn it off, reset your password and turn it back on.",
+			Link:      frontendLink("/account/login"),
+			LinkLabel: "Sign in",
+		})
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user, after checking a TOTP code.
+func RegenerateRecoveryCodes(user *User, code string) ([]string, error) {
+	var codes []string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkSecondFactor(tx, user.ID, code, false); err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// --- Sign In ---
+
+// signIn signs a user in once their password, or their identity provider, has been checked.
+// Users with two-factor authentication enabled get a partial session instead, completed by
+// signinTwoFactorHandler; signIn then returns true.
+func signIn(c *gin.Context, user *User) (bool, error) {
+	enabled, err := TwoFactorEnabled(user.ID)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		return false, startSession(c, user)
+	}
+
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(sessionPendingUserIDKey, user.ID.String())
+	session.Set(sessionPendingSinceKey, time.Now().Unix())
+	session.Set(sessionPendingAttemptsKey, 0)
+	return true, session.Save()
+}
+
+// pendingUser returns the user of the partial session of the request, or nil if there is
+// none or it has expired.
+func pendingUser(c *gin.Context) (*User, error) {
+	session := sessions.Default(c)
+	value, _ := session.Get(sessionPendingUserIDKey).(string)
+	since, _ := session.Get(sessionPendingSinceKey).(int64)
+	userID, err := uuid.Parse(value)
+	if err != nil || time.Since(time.Unix(since, 0)) > twoFactorPendingTTL {
+		return nil, nil
+	}
+
+	var user User
+	if err := DB.Limit(1).Find(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == uuid.Nil {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// --- Handlers ---
+
+// signinTwoFactorHandler handles POST /api/account/signin/2fa requests, completing the sign
+// in started by signinHandler with a TOTP code or a recovery code.
+func signinTwoFactorHandler(c *gin.Context) {
+	var codeRequest TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&codeRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := pendingUser(c)
+	if err != nil {
+		log.Printf("Error retrieving the user of a partial session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign in"})
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in with your password first"})
+		return
+	}
+	if !checkLoginThrottle(c, user.Email) {
+		return
+	}
+
+	if err := VerifySecondFactor(user.ID, codeRequest.Code); err != nil {
+		if !errors.Is(err, ErrInvalidCode) {
+			log.Printf("Error checking the second factor of user %s: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign in"})
+			return
+		}
+		recordLoginFailure(c, user.Email, user, LoginFailureWrongCode)
+
+		session := sessions.Default(c)
+		attempts, _ := session.Get(sessionPendingAttemptsKey).(int)
+		if attempts+1 >= twoFactorMaxAttempts {
+			session.Clear()
+			session.Save()
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many wrong codes, sign in with your password again"})
+			return
+		}
+		session.Set(sessionPendingAttemptsKey, attempts+1)
+		session.Save()
+		c.JSON(http.StatusForbidden, gin.H{"error": "Wrong code"})
+		return
+	}
+
+	if err := startSession(c, user); err != nil {
+		log.Printf("Error saving session for user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not sign in"})
+		return
+	}
+	if err := ResetLoginFailures(user.Email); err != nil {
+		log.Printf("Error resetting failed sign ins of user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": user.Username, "email": user.Email, "message": "Signed in successfully"})
+}
+
+// twoFactorStatusHandler handles GET /api/account/2fa requests.
+func twoFactorStatusHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	twoFactor, err := GetTwoFactor(DB, user.ID)
+	if err != nil {
+		log.Printf("Error retrieving two-factor authentication of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving two-factor authentication"})
+		return
+	}
+
+	var remaining int64
+	if twoFactor.Enabled() {
+		if err := DB.Model(&RecoveryCode{}).Where("user_id = ?", user.ID).Count(&remaining).Error; err != nil {
+			log.Printf("Error counting recovery codes of user %s: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving two-factor authentication"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"enabled": true, "enabled_at": twoFactor.ConfirmedAt, "recovery_codes_left": remaining})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"enabled": false})
+}
+
+// enrollTwoFactorHandler handles POST /api/account/2fa/enroll requests. The secret only
+// protects sign ins once confirmed with confirmTwoFactorHandler.
+func enrollTwoFactorHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	enrollment, err := EnrollTwoFactor(user)
+	if err != nil {
+		if errors.Is(err, ErrTwoFactorEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled, disable it first"})
+			return
+		}
+		log.Printf("Error enrolling user %s in two-factor authentication: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not set up two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// confirmTwoFactorHandler handles POST /api/account/2fa/confirm requests. It answers the
+// recovery codes, which are never shown again.
+func confirmTwoFactorHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	var codeRequest TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&codeRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := ConfirmTwoFactor(user, codeRequest.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidCode):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Wrong code, check the clock of your device"})
+		case errors.Is(err, ErrTwoFactorNotEnabled):
+			c.JSON(http.StatusConflict, gin.H{"error": "Start the setup of two-factor authentication first"})
+		case errors.Is(err, ErrTwoFactorEnabled):
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		default:
+			log.Printf("Error confirming two-factor authentication of user %s: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not enable two-factor authentication"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes, "message": "Two-factor authentication enabled, keep the recovery codes somewhere safe"})
+}
+
+// disableTwoFactorHandler handles POST /api/account/2fa/disable requests, with a TOTP code or a recovery code.
+func disableTwoFactorHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	var codeRequest TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&codeRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := DisableTwoFactor(user, codeRequest.Code); err != nil {
+		twoFactorCodeError(c, user, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// regenerateRecoveryCodesHandler handles POST /api/account/2fa/recovery-codes requests, with
+// a TOTP code. The previous recovery codes stop working.
+func regenerateRecoveryCodesHandler(c *gin.Context) {
+	user := currentUser(c)
+
+	var codeRequest TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&codeRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := RegenerateRecoveryCodes(user, codeRequest.Code)
+	if err != nil {
+		twoFactorCodeError(c, user, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// twoFactorCodeError answers a request whose code couldn't be checked.
+func twoFactorCodeError(c *gin.Context, user *User, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidCode):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Wrong code"})
+	case errors.Is(err, ErrTwoFactorNotEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not enabled"})
+	default:
+		log.Printf("Error updating two-factor authentication of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update two-factor authentication"})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func totpCode(t *testing.T, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(testTOTPSecret, at, totp.ValidateOpts{
+		Period:    totpPeriod,
+		Digits:    totpDigits,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current period", code: totpCode(t, now), wantStep: step, wantOK: true},
+		{name: "previous period", code: totpCode(t, now.Add(-totpPeriod*time.Second)), wantStep: step - 1, wantOK: true},
+		{name: "next period", code: totpCode(t, now.Add(totpPeriod*time.Second)), wantStep: step + 1, wantOK: true},
+		{name: "spaces around", code: " " + totpCode(t, now) + " ", wantStep: step, wantOK: true},
+		{name: "beyond the skew before", code: totpCode(t, now.Add(-2*totpPeriod*time.Second))},
+		{name: "beyond the skew after", code: totpCode(t, now.Add(2*totpPeriod*time.Second))},
+		{name: "wrong length", code: "12345"},
+		{name: "empty", code: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := matchTOTP(testTOTPSecret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("matchTOTP() = (%d, %v), want (%d, %v)", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTwoFactorMatchCodeReplay(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	step := now.Unix() / totpPeriod
+	code := totpCode(t, now)
+
+	tests := []struct {
+		name     string
+		lastStep int64
+		wantOK   bool
+	}{
+		{name: "first use", lastStep: step - 2, wantOK: true},
+		{name: "same code again", lastStep: step},
+		{name: "older than the last code", lastStep: step + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			twoFactor := &TwoFactor{Secret: testTOTPSecret, LastStep: tt.lastStep}
+			gotStep, ok := twoFactor.matchCode(code, now)
+			if ok != tt.wantOK {
+				t.Fatalf("matchCode() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && gotStep != step {
+				t.Errorf("matchCode() step = %d, want %d", gotStep, step)
+			}
+		})
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{code: "abcde-fghij", want: "abcde-fghij"},
+		{code: "ABCDE-FGHIJ", want: "abcde-fghij"},
+		{code: "abcdefghij", want: "abcde-fghij"},
+		{code: " abcde fghij ", want: "abcde-fghij"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// FieldErrors maps the ID of a question to the problem found with its answer.
+type FieldErrors map[string]string
+
+// ValidateAnswers checks the answers of a response against the questions of a form.
+// Every problem is collected, so the respondent can fix all of them at once;
+// an empty result means the answers are valid.
+func ValidateAnswers(questions []Question, answers []Answer) FieldErrors {
+	fieldErrors := FieldErrors{}
+
+	questionMap := make(map[uuid.UUID]*Question, len(questions)) // Map question ID to Question for easy lookup
+	for i := range questions {
+		questionMap[questions[i].ID] = &questions[i]
+	}
+
+	answeredQuestions := make(map[uuid.UUID]bool) // Track answered question IDs
+	for i := range answers {
+		answer := &answers[i]
+		key := answer.QuestionID.String()
+
+		// Check if the QuestionID submitted actually exists in the target form
+		question, exists := questionMap[answer.QuestionID]
+		if !exists {
+			fieldErrors[key] = "is not a question of this form"
+			continue
+		}
+		if answeredQuestions[answer.QuestionID] {
+			fieldErrors[key] = "is answered more than once"
+			continue
+		}
+		answeredQuestions[answer.QuestionID] = true
+
+		// Empty answers are allowed for questions that are not required
+		if answer.IsEmpty() {
+			if question.IsRequired {
+				fieldErrors[key] = "an answer is required"
+			}
+			continue
+		}
+
+		// Questions saved before types were checked may have a type that is no longer registered
+		questionType, known := LookupQuestionType(question.Type)
+		if !known {
+			continue
+		}
+		if questionType.MultiValue() && answer.Value != "" {
+			fieldErrors[key] = "must be answered with a list of options in values"
+			continue
+		}
+		if !questionType.MultiValue() && len(answer.Values) > 0 {
+			fieldErrors[key] = "must be answered with a single value"
+			continue
+		}
+		if err := questionType.ValidateAnswer(&question.Config, answer); err != nil {
+			fieldErrors[key] = err.Error()
+		}
+	}
+
+	// Check if all required questions from the form were answered
+	for _, question := range questions {
+		if question.IsRequired && !answeredQuestions[question.ID] {
+			fieldErrors[question.ID.String()] = "an answer is required"
+		}
+	}
+
+	return fieldErrors
+}
+
+// ValidateSection checks the answers to the questions of one section that the
+// respondent is shown, given every answer so far. Answers to other sections are
+// ignored. It also returns the section that comes next, or nil at the end of the form.
+func ValidateSection(form *Form, sectionID uuid.UUID, answers []Answer) (FieldErrors, *uuid.UUID) {
+	shown, kept := ApplyLogic(form.Questions, answers)
+
+	inSection := make(map[uuid.UUID]bool)
+	for _, question := range form.Questions {
+		if question.SectionID != nil && *question.SectionID == sectionID {
+			inSection[question.ID] = true
+		}
+	}
+
+	var sectionQuestions []Question
+	isShown := make(map[uuid.UUID]bool, len(shown))
+	for _, question := range shown {
+		isShown[question.ID] = true
+		if inSection[question.ID] {
+			sectionQuestions = append(sectionQuestions, question)
+		}
+	}
+	var sectionAnswers []Answer
+	for _, answer := range kept {
+		if inSection[answer.QuestionID] {
+			sectionAnswers = append(sectionAnswers, answer)
+		}
+	}
+
+	// The next section is the first later one with a question the respondent is shown.
+	var next *uuid.UUID
+	after := false
+	for _, section := range form.Sections {
+		if after && slices.ContainsFunc(section.Questions, func(q Question) bool { return isShown[q.ID] }) {
+			next = &section.ID
+			break
+		}
+		after = after || section.ID == sectionID
+	}
+
+	return ValidateAnswers(sectionQuestions, sectionAnswers), next
+}
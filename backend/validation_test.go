@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateAnswers(t *testing.T) {
+	name := Question{ID: uuid.New(), Text: "Name", Type: "text", IsRequired: true}
+	age := Question{ID: uuid.New(), Text: "Age", Type: "number"}
+	color := Question{ID: uuid.New(), Text: "Color", Type: "radio", Config: QuestionConfig{
+		Choices: []Choice{{ID: "red", Label: "Red"}, {ID: "blue", Label: "Blue"}},
+	}}
+	legacy := Question{ID: uuid.New(), Text: "Legacy", Type: "rating"}
+	questions := []Question{name, age, color, legacy}
+
+	stranger := uuid.New()
+
+	tests := []struct {
+		name    string
+		answers []Answer
+		want    FieldErrors
+	}{
+		{
+			name: "valid answers",
+			answers: []Answer{
+				{QuestionID: name.ID, Value: "Ada"},
+				{QuestionID: age.ID, Value: "36"},
+				{QuestionID: color.ID, Value: "blue"},
+			},
+			want: FieldErrors{},
+		},
+		{
+			name:    "required question missing",
+			answers: []Answer{{QuestionID: age.ID, Value: "36"}},
+			want:    FieldErrors{name.ID.String(): "an answer is required"},
+		},
+		{
+			name:    "required question blank",
+			answers: []Answer{{QuestionID: name.ID, Value: "   "}},
+			want:    FieldErrors{name.ID.String(): "an answer is required"},
+		},
+		{
+			name:    "optional question blank",
+			answers: []Answer{{QuestionID: name.ID, Value: "Ada"}, {QuestionID: age.ID, Value: ""}},
+			want:    FieldErrors{},
+		},
+		{
+			name:    "wrong type",
+			answers: []Answer{{QuestionID: name.ID, Value: "Ada"}, {QuestionID: age.ID, Value: "old"}},
+			want:    FieldErrors{age.ID.String(): "must be a number"},
+		},
+		{
+			name:    "unknown choice",
+			answers: []Answer{{QuestionID: name.ID, Value: "Ada"}, {QuestionID: color.ID, Value: "green"}},
+			want:    FieldErrors{color.ID.String(): "must be one of the question's options"},
+		},
+		{
+			name:    "question of another form",
+			answers: []Answer{{QuestionID: name.ID, Value: "Ada"}, {QuestionID: stranger, Value: "x"}},
+			want:    FieldErrors{stranger.String(): "is not a question of this form"},
+		},
+		{
+			name:    "answered twice",
+			answers: []Answer{{QuestionID: name.ID, Value: "Ada"}, {QuestionID: name.ID, Value: "Bob"}},
+			want:    FieldErrors{name.ID.String(): "is answered more than once"},
+		},
+		{
+			name:    "unregistered type is not checked",
+			answers: []Answer{{QuestionID: name.ID, Value: "Ada"}, {QuestionID: legacy.ID, Value: "5 stars"}},
+			want:    FieldErrors{},
+		},
+		{
+			name: "every problem is reported",
+			answers: []Answer{
+				{QuestionID: age.ID, Value: "old"},
+				{QuestionID: color.ID, Value: "green"},
+			},
+			want: FieldErrors{
+				name.ID.String():  "an answer is required",
+				age.ID.String():   "must be a number",
+				color.ID.String(): "must be one of the question's options",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ValidateAnswers(questions, tt.answers)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateAnswers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
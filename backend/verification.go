@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// --- Database Functions ---
+
+// IssueEmailVerification emails a new verification link to a user, replacing the
+// codes issued before so only the latest link works. Only the hash of the code is kept,
+// with the address the link was requested from.
+func IssueEmailVerification(tx *gorm.DB, user *User, ip string) error {
+	code, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND purpose = ?", user.ID, VerificationPurposeEmail).Delete(&Verification{}).Error; err != nil {
+			return err
+		}
+
+		verification := Verification{
+			UserID:    user.ID,
+			Purpose:   VerificationPurposeEmail,
+			TokenHash: hash,
+			RequestIP: ip,
+			ExpiresAt: time.Now().Add(AppConfig.EmailVerificationTTL),
+		}
+		if err := tx.Create(&verification).Error; err != nil {
+			return err
+		}
+
+		return QueueEmail(tx, MailVerification, user.Email, VerificationMail{
+			Username:  user.Username,
+			Link:      publicLink("/api/account/verify?verificationCode=" + url.QueryEscape(code)),
+			ExpiresAt: verification.ExpiresAt,
+		})
+	})
+}
+
+// VerifyEmail marks the user a verification code was issued to as verified, using up the code.
+// Codes issued before they were hashed are still accepted.
+func VerifyEmail(code string) (*User, error) {
+	if code == "" {
+		return nil, ErrInvalidToken
+	}
+
+	var user User
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var verification Verification
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Limit(1).
+			Where("purpose = ?", VerificationPurposeEmail).
+			Where(tx.Where("token_hash = ?", hashToken(code)).Or("verification_code = ?", code)).
+			Find(&verification).Error
+		if err != nil {
+			return err
+		}
+		if verification.ID == 0 {
+			return ErrInvalidToken
+		}
+		if time.Now().After(verification.ExpiresAt) {
+			return ErrTokenExpired
+		}
+
+		if err := tx.First(&user, "id = ?", verification.UserID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("verified", true).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&verification).Error // The code can only be used once
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ResendEmailVerification sends a new verification link to the user with the given
+// email, if there is one and it is not verified yet. A link is sent at most once every
+// VERIFICATION_RESEND_INTERVAL per account, and VERIFICATION_RESEND_IP_MAX links an hour
+// per address the requests come from. Over the account limit nothing is sent but no
+// error is returned either, so the answer doesn't tell which emails have accounts.
+func ResendEmailVerification(email, ip string, now time.Time) error {
+	if err := checkTokenRequestAllowed(VerificationPurposeEmail, ip, AppConfig.VerificationResendIPMax, now); err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		// Locking the user serializes concurrent requests for the same account
+		var user User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Limit(1).
+			Find(&user, "lower(email) = lower(?)", strings.TrimSpace(email)).Error
+		if err != nil {
+			return err
+		}
+		if user.ID == uuid.Nil || user.Verified {
+			return nil
+		}
+
+		// Links that were replaced or used count too
+		var recent int64
+		err = tx.Unscoped().Model(&Verification{}).
+			Where("user_id = ? AND purpose = ? AND created_at > ?", user.ID, VerificationPurposeEmail, now.Add(-AppConfig.VerificationResendInterval)).
+			Count(&recent).Error
+		if err != nil || recent > 0 {
+			return err
+		}
+		return IssueEmailVerification(tx, &user, ip)
+	})
+}
+
+// --- Handlers ---
+
+// verifyHandler handles POST /api/account/verify?verificationCode=... requests.
+func verifyHandler(c *gin.Context) {
+	user, err := VerifyEmail(c.Query("verificationCode"))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidToken):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid verification code"})
+		case errors.Is(err, ErrTokenExpired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Verification code has expired"})
+		default:
+			log.Printf("Error verifying email: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": user.Username, "email": user.Email, "message": "Email verified successfully"})
+}
+
+// verifyLinkHandler handles GET /api/account/verify?verificationCode=... requests, made
+// by following the link of a verification email. It redirects to the sign in page of
+// the frontend with the result: ?verification=success, expired, invalid or error.
+func verifyLinkHandler(c *gin.Context) {
+	result := "success"
+	if _, err := VerifyEmail(c.Query("verificationCode")); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidToken):
+			result = "invalid"
+		case errors.Is(err, ErrTokenExpired):
+			result = "expired"
+		default:
+			log.Printf("Error verifying email: %v", err)
+			result = "error"
+		}
+	}
+
+	c.Redirect(http.StatusSeeOther, frontendLink("/account/login?verification="+result))
+}
+
+// resendVerificationHandler handles POST /api/account/verify/resend requests, limited as
+// ResendEmailVerification tells. The answer is the same whether or not an account uses
+// the email.
+func resendVerificationHandler(c *gin.Context) {
+	var resendRequest ResendVerificationRequest
+	if err := c.ShouldBindJSON(&resendRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ResendEmailVerification(resendRequest.Email, c.ClientIP(), time.Now()); err != nil {
+		var limited *TokenRequestLimitedError
+		if errors.As(err, &limited) {
+			setRetryAfter(c, limited.Wait)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many verification emails were requested, try again later"})
+			return
+		}
+		log.Printf("Error resending verification: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not send a verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an unverified account uses this email, a new verification link has been sent to it"})
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// --- Entities ---
+
+// FormVersion is an immutable snapshot of a form and its questions.
+// A new version is recorded every time the form or its questions change.
+type FormVersion struct {
+	ID          uuid.UUID  `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	FormID      uuid.UUID  `json:"form_id" gorm:"type:uuid;uniqueIndex:idx_form_versions_form_version"`
+	Version     int        `json:"version" gorm:"uniqueIndex:idx_form_versions_form_version"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Sections    []Section  `json:"sections" gorm:"type:jsonb;serializer:json"` // Without their questions, which carry their section_id
+	Questions   []Question `json:"questions" gorm:"type:jsonb;serializer:json"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// FieldChange describes a single field whose value differs between two versions.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// QuestionChange lists the fields of a question that changed between two versions.
+type QuestionChange struct {
+	QuestionID uuid.UUID     `json:"question_id"`
+	Changes    []FieldChange `json:"changes"`
+}
+
+// FormVersionDiff is the result of comparing two versions of the same form.
+type FormVersionDiff struct {
+	FormID           uuid.UUID        `json:"form_id"`
+	From             int              `json:"from"`
+	To               int              `json:"to"`
+	Changes          []FieldChange    `json:"changes"`
+	AddedQuestions   []Question       `json:"added_questions"`
+	RemovedQuestions []Question       `json:"removed_questions"`
+	ChangedQuestions []QuestionChange `json:"changed_questions"`
+}
+
+// --- Database Functions ---
+
+// SnapshotForm records the current state of a form as its next version.
+// It must run inside the transaction that changed the form, so the snapshot
+// and the change are committed together. form.Version is updated in place.
+func SnapshotForm(tx *gorm.DB, form *Form) (*FormVersion, error) {
+	var current Form
+	// Lock the form row so concurrent edits get consecutive version numbers.
+	if err := withSections(tx.Clauses(clause.Locking{Strength: "UPDATE"})).
+		First(&current, "id = ?", form.ID).Error; err != nil {
+		return nil, err
+	}
+	current.collectQuestions()
+
+	sections := make([]Section, 0, len(current.Sections))
+	for _, section := range current.Sections {
+		section.Questions = nil
+		sections = append(sections, section)
+	}
+
+	version := FormVersion{
+		FormID:      current.ID,
+		Version:     current.Version + 1,
+		Title:       current.Title,
+		Description: current.Description,
+		Sections:    sections,
+		Questions:   current.Questions,
+	}
+
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&Form{}).Where("id = ?", current.ID).UpdateColumn("version", version.Version).Error; err != nil {
+		return nil, err
+	}
+
+	form.Version = version.Version
+	return &version, nil
+}
+
+// BackfillFormVersions records an initial version for forms created before versioning existed.
+func BackfillFormVersions() error {
+	var forms []Form
+	if err := DB.Where("version = 0").Find(&forms).Error; err != nil {
+		return err
+	}
+
+	for i := range forms {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			_, err := SnapshotForm(tx, &forms[i])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(forms) > 0 {
+		log.Printf("Recorded initial versions for %d forms", len(forms))
+	}
+	return nil
+}
+
+// GetFormVersions retrieves all the versions of a form, newest first.
+func GetFormVersions(formID string) ([]FormVersion, error) {
+	var versions []FormVersion
+	result := DB.Where("form_id = ?", formID).Order("version desc").Find(&versions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if versions == nil {
+		versions = []FormVersion{}
+	}
+	return versions, nil
+}
+
+// GetFormVersion retrieves a single version of a form.
+func GetFormVersion(formID string, version int) (*FormVersion, error) {
+	var formVersion FormVersion
+	result := DB.First(&formVersion, "form_id = ? AND version = ?", formID, version)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &formVersion, nil
+}
+
+// DiffFormVersions compares two versions of a form. Questions are matched on their ID.
+func DiffFormVersions(from, to *FormVersion) FormVersionDiff {
+	diff := FormVersionDiff{
+		FormID:           from.FormID,
+		From:             from.Version,
+		To:               to.Version,
+		Changes:          []FieldChange{},
+		AddedQuestions:   []Question{},
+		RemovedQuestions: []Question{},
+		ChangedQuestions: []QuestionChange{},
+	}
+
+	if from.Title != to.Title {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "title", From: from.Title, To: to.Title})
+	}
+	if from.Description != to.Description {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "description", From: from.Description, To: to.Description})
+	}
+
+	fromQuestions := make(map[uuid.UUID]Question, len(from.Questions))
+	for _, q := range from.Questions {
+		fromQuestions[q.ID] = q
+	}
+	toQuestions := make(map[uuid.UUID]bool, len(to.Questions))
+
+	for _, q := range to.Questions {
+		toQuestions[q.ID] = true
+		previous, existed := fromQuestions[q.ID]
+		if !existed {
+			diff.AddedQuestions = append(diff.AddedQuestions, q)
+			continue
+		}
+		if changes := diffQuestion(previous, q); len(changes) > 0 {
+			diff.ChangedQuestions = append(diff.ChangedQuestions, QuestionChange{QuestionID: q.ID, Changes: changes})
+		}
+	}
+
+	for _, q := range from.Questions {
+		if !toQuestions[q.ID] {
+			diff.RemovedQuestions = append(diff.RemovedQuestions, q)
+		}
+	}
+
+	return diff
+}
+
+// diffQuestion compares the JSON representation of two questions, so every
+// field exposed by the API takes part in the comparison. Timestamps are ignored.
+func diffQuestion(from, to Question) []FieldChange {
+	fromFields := questionFields(from)
+	toFields := questionFields(to)
+
+	keys := make([]string, 0, len(toFields))
+	for key := range toFields {
+		keys = append(keys, key)
+	}
+	for key := range fromFields {
+		if _, ok := toFields[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []FieldChange{}
+	for _, key := range keys {
+		if key == "created_at" || key == "updated_at" {
+			continue
+		}
+		if !reflect.DeepEqual(fromFields[key], toFields[key]) {
+			changes = append(changes, FieldChange{Field: key, From: fromFields[key], To: toFields[key]})
+		}
+	}
+	return changes
+}
+
+func questionFields(q Question) map[string]any {
+	fields := map[string]any{}
+	if data, err := json.Marshal(q); err == nil {
+		_ = json.Unmarshal(data, &fields)
+	}
+	return fields
+}
+
+// --- Handlers ---
+
+// listFormVersionsHandler handles GET /forms/:formId/versions requests.
+func listFormVersionsHandler(c *gin.Context) {
+	formID := c.Param("formId")
+
+	versions, err := GetFormVersions(formID)
+	if err != nil {
+		log.Printf("Error retrieving versions for form %s: %v", formID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving form versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// getFormVersionHandler handles GET /forms/:formId/versions/:version requests.
+func getFormVersionHandler(c *gin.Context) {
+	formID := c.Param("formId")
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version number"})
+		return
+	}
+
+	formVersion, err := GetFormVersion(formID, version)
+	if err != nil {
+		log.Printf("Error retrieving version %d of form %s: %v", version, formID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving form version"})
+		return
+	}
+	if formVersion == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Form version not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, formVersion)
+}
+
+// diffFormVersionsHandler handles GET /forms/:formId/versions/diff?from=N&to=M requests.
+func diffFormVersionsHandler(c *gin.Context) {
+	formID := c.Param("formId")
+
+	fromNumber, errFrom := strconv.Atoi(c.Query("from"))
+	toNumber, errTo := strconv.Atoi(c.Query("to"))
+	if errFrom != nil || errTo != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameters 'from' and 'to' must be version numbers"})
+		return
+	}
+
+	from, err := GetFormVersion(formID, fromNumber)
+	if err != nil {
+		log.Printf("Error retrieving version %d of form %s: %v", fromNumber, formID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving form version"})
+		return
+	}
+	to, err := GetFormVersion(formID, toNumber)
+	if err != nil {
+		log.Printf("Error retrieving version %d of form %s: %v", toNumber, formID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving form version"})
+		return
+	}
+	if from == nil || to == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Form version not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, DiffFormVersions(from, to))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDiffFormVersions(t *testing.T) {
+	kept := uuid.New()
+	removed := uuid.New()
+	added := uuid.New()
+
+	base := Question{ID: kept, Text: "Name", Type: "text"}
+
+	tests := []struct {
+		name     string
+		from, to FormVersion
+		changes  []string
+		added    []uuid.UUID
+		removed  []uuid.UUID
+		changed  map[uuid.UUID][]string
+	}{
+		{
+			name: "identical versions",
+			from: FormVersion{Title: "Survey", Questions: []Question{base}},
+			to:   FormVersion{Title: "Survey", Questions: []Question{base}},
+		},
+		{
+			name:    "title and description",
+			from:    FormVersion{Title: "Survey", Description: "old"},
+			to:      FormVersion{Title: "Poll", Description: "new"},
+			changes: []string{"title", "description"},
+		},
+		{
+			name:    "added and removed questions",
+			from:    FormVersion{Questions: []Question{base, {ID: removed, Text: "Age"}}},
+			to:      FormVersion{Questions: []Question{base, {ID: added, Text: "Email"}}},
+			added:   []uuid.UUID{added},
+			removed: []uuid.UUID{removed},
+		},
+		{
+			name:    "changed question fields",
+			from:    FormVersion{Questions: []Question{base}},
+			to:      FormVersion{Questions: []Question{{ID: kept, Text: "Full name", Type: "text", IsRequired: true}}},
+			changed: map[uuid.UUID][]string{kept: {"is_required", "text"}},
+		},
+		{
+			name: "timestamps are ignored",
+			from: FormVersion{Questions: []Question{base}},
+			to:   FormVersion{Questions: []Question{{ID: kept, Text: "Name", Type: "text", CreatedAt: time.Now(), UpdatedAt: time.Now()}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffFormVersions(&tt.from, &tt.to)
+
+			var changes []string
+			for _, change := range diff.Changes {
+				changes = append(changes, change.Field)
+			}
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("changes = %v, want %v", changes, tt.changes)
+			}
+
+			if ids := questionIDs(diff.AddedQuestions); !reflect.DeepEqual(ids, tt.added) {
+				t.Errorf("added = %v, want %v", ids, tt.added)
+			}
+			if ids := questionIDs(diff.RemovedQuestions); !reflect.DeepEqual(ids, tt.removed) {
+				t.Errorf("removed = %v, want %v", ids, tt.removed)
+			}
+
+			changed := map[uuid.UUID][]string{}
+			for _, question := range diff.ChangedQuestions {
+				for _, change := range question.Changes {
+					changed[question.QuestionID] = append(changed[question.QuestionID], change.Field)
+				}
+			}
+			if len(changed) != len(tt.changed) || (len(changed) > 0 && !reflect.DeepEqual(changed, tt.changed)) {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func questionIDs(questions []Question) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, q := range questions {
+		ids = append(ids, q.ID)
+	}
+	return ids
+}
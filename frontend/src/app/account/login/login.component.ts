@@ -4,7 +4,7 @@ import { ReactiveFormsModule } from '@angular/forms';
 import { FormBuilder, FormGroup, Validators } from '@angular/forms';
 import { ClarityModule } from '@clr/angular';
 import { AccountService } from '../../../services/account-service.service';
-import { Router } from '@angular/router';
+import { ActivatedRoute, Router } from '@angular/router';
 
 @Component({
   selector: 'app-login',
@@ -17,9 +17,34 @@ export class LoginComponent {
   loginForm: FormGroup;
   errorMessage: string | null = null; 
   showSuccessModal: boolean = false;
+  // Result of following a verification link: success, expired, invalid or error
+  verification: string | null = null;
+  resendMessage: string | null = null;
+  // Result of following the link sent to a new email address: success, expired, invalid, taken or error
+  emailChange: string | null = null;
+  // Result of a failed single sign-on: denied, expired, unverified, no_account, unavailable or error
+  sso: string | null = null;
+  ssoLoginUrl: string | null = null;
+  // Set once the password is checked, for accounts with two-factor authentication
+  twoFactorRequired: boolean = false;
+  twoFactorCode: string = '';
 
 
-  constructor(private fb: FormBuilder, private accountService: AccountService, private router: Router) {
+  constructor(private fb: FormBuilder, private accountService: AccountService, private router: Router, private route: ActivatedRoute) {
+    this.verification = this.route.snapshot.queryParamMap.get('verification');
+    this.sso = this.route.snapshot.queryParamMap.get('sso');
+    this.emailChange = this.route.snapshot.queryParamMap.get('email_change');
+    this.twoFactorRequired = this.route.snapshot.queryParamMap.get('two_factor') === 'required';
+    this.accountService.singleSignOn().subscribe({
+      next: (response) => {
+        if (response.enabled && response.login_url) {
+          this.ssoLoginUrl = `${response.login_url}?redirect=${encodeURIComponent('/forms')}`;
+        }
+      },
+      error: () => {
+        // Single sign-on stays hidden
+      },
+    });
     this.loginForm = this.fb.group({
       email: ['', [Validators.required, Validators.email]],
       password: ['', [Validators.required, Validators.minLength(6)]],
@@ -34,6 +59,11 @@ export class LoginComponent {
       };
       this.accountService.signin(signinDto).subscribe({
         next: (response) => {
+          if (response.two_factor_required) {
+            this.errorMessage = null;
+            this.twoFactorRequired = true;
+            return;
+          }
           console.log('Login successful', response);
           this.loginForm.reset();
           this.showSuccessModal = true;
@@ -49,6 +79,50 @@ export class LoginComponent {
     }
   }
 
+  submitTwoFactorCode(): void {
+    this.accountService.signinTwoFactor(this.twoFactorCode.trim()).subscribe({
+      next: () => {
+        this.loginForm.reset();
+        this.twoFactorRequired = false;
+        this.router.navigate(['/forms']);
+      },
+      error: () => {
+        this.errorMessage = 'Wrong code. After too many attempts, log in with your password again.';
+      },
+    });
+  }
+
+  resendVerification(): void {
+    const email = this.loginForm.value.email;
+    if (!email) {
+      this.resendMessage = 'Enter your email above, then ask for a new link.';
+      return;
+    }
+    this.accountService.resendVerification(email).subscribe({
+      next: () => {
+        this.resendMessage = 'If your account is not verified yet, a new verification link has been sent, check your inbox.';
+      },
+      error: () => {
+        this.resendMessage = 'Too many links were requested, wait a while before asking again.';
+      },
+    });
+  }
+
+  ssoErrorMessage(): string {
+    switch (this.sso) {
+      case 'unverified':
+        return 'Your identity provider has not verified your email address.';
+      case 'no_account':
+        return 'No account uses your email address. Sign up first, then use single sign-on.';
+      case 'denied':
+        return 'Single sign-on was cancelled.';
+      case 'expired':
+        return 'The single sign-on attempt expired, try again.';
+      default:
+        return 'Single sign-on failed, try again later.';
+    }
+  }
+
   closeSuccessModal(): void {
     this.showSuccessModal = false;
   }
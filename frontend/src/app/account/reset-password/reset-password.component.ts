@@ -0,0 +1,51 @@
+import { CommonModule } from '@angular/common';
+import { Component } from '@angular/core';
+import { ReactiveFormsModule } from '@angular/forms';
+import { FormBuilder, FormGroup, Validators } from '@angular/forms';
+import { ClarityModule } from '@clr/angular';
+import { AccountService } from '../../../services/account-service.service';
+import { ActivatedRoute, Router } from '@angular/router';
+
+/**
+ * Page the password reset emails link to: the token comes from the ?token= query parameter.
+ */
+@Component({
+  selector: 'app-reset-password',
+  standalone: true,
+  imports: [ReactiveFormsModule, CommonModule, ClarityModule],
+  templateUrl: './reset-password.component.html',
+  styleUrl: './reset-password.component.css',
+})
+export class ResetPasswordComponent {
+  resetForm: FormGroup;
+  token: string | null;
+  errorMessage: string | null = null;
+
+  constructor(private fb: FormBuilder, private accountService: AccountService, private route: ActivatedRoute, private router: Router) {
+    this.token = this.route.snapshot.queryParamMap.get('token');
+    this.resetForm = this.fb.group({
+      password: ['', [Validators.required, Validators.minLength(8)]],
+      confirmPassword: ['', [Validators.required]],
+    });
+  }
+
+  onSubmit() {
+    if (!this.token || this.resetForm.invalid) {
+      return;
+    }
+    if (this.resetForm.value.password !== this.resetForm.value.confirmPassword) {
+      this.errorMessage = 'The passwords do not match.';
+      return;
+    }
+
+    this.accountService.resetPassword(this.token, this.resetForm.value.password).subscribe({
+      next: () => {
+        this.router.navigate(['/account/login']);
+      },
+      error: (error) => {
+        console.error('Password reset failed', error);
+        this.errorMessage = 'The link is invalid or has expired. Ask for a new one.';
+      },
+    });
+  }
+}
@@ -5,6 +5,7 @@ import { FormsNewComponent } from './forms-new/forms-new.component';
 import { ResponsesNewComponent } from './responses-new/responses-new.component';
 import { LoginComponent } from './account/login/login.component';
 import { RegistrationComponent } from './account/registration/registration.component';
+import { ResetPasswordComponent } from './account/reset-password/reset-password.component';
 import { QuestionsCreateComponent } from './forms/questions-create/questions-create.component';
 
 export const routes: Routes = [
@@ -17,6 +18,7 @@ export const routes: Routes = [
     // Account routes
     { path: 'account/login', component: LoginComponent},
     { path: 'account/register', component: RegistrationComponent},
+    { path: 'account/reset-password', component: ResetPasswordComponent},
 
     { path: '', redirectTo: '/forms', pathMatch: 'full'}
 ];
@@ -1,6 +1,6 @@
 import { Component, OnInit, OnDestroy, CUSTOM_ELEMENTS_SCHEMA } from '@angular/core';
 import { ActivatedRoute, RouterLink } from '@angular/router';
-import { FormService, Form, Question, FormResponse, NewFormResponse } from '../../services/gforms-backend.service'; // Adjust path if needed
+import { FormService, Form, Question, FormResponse, NewFormResponse, FormTransition } from '../../services/gforms-backend.service'; // Adjust path if needed
 import { Subscription } from 'rxjs';
 import { CommonModule } from '@angular/common';
 import { ClarityModule } from '@clr/angular'; // Import ClarityModule if using Clarity components
@@ -94,6 +94,20 @@ export class FormDetailComponent implements OnInit, OnDestroy {
     }
   }
 
+  changeStatus(transition: FormTransition): void {
+    if (!this.form) return;
+    this.formService.transitionForm(this.form.id, transition).subscribe({
+      next: (data) => {
+        this.form = data;
+        console.log('Form status changed:', this.form.status);
+      },
+      error: (err) => {
+        console.error('Error changing form status:', err);
+        this.error = err.message || 'Could not change form status.';
+      }
+    });
+  }
+
   sendResponse(): void {
     if (this.form?.questions === undefined || this.form?.questions.length === 0) {
       console.error('Form has no questions to submit.');
@@ -5,6 +5,7 @@ import {
   Form,
   FormService,
   Question,
+  QuestionConfig,
 } from '../../../services/gforms-backend.service';
 import { Subscription } from 'rxjs';
 import { ActivatedRoute, Router } from '@angular/router';
@@ -14,7 +15,7 @@ import { ClarityModule } from '@clr/angular';
 class QuestionRequest {
   text!: string;
   type!: string;
-  extra_info!: string;
+  config!: QuestionConfig;
   is_required!: boolean;
 }
 
@@ -92,13 +93,12 @@ export class QuestionsCreateComponent implements OnInit {
       id: crypto.randomUUID(),
       text: '',
       type: '', // Default to empty, user must select
-      extra_info: '',
+      config: {},
       is_required: false,
       created_at: '',
       updated_at: '',
       required: false,
       description: '',
-      placeholder: ''
     });
   }
 
@@ -124,7 +124,7 @@ export class QuestionsCreateComponent implements OnInit {
         const questionRequest: QuestionRequest = {
           text: q.text,
           type: q.type,
-          extra_info: q.extra_info,
+          config: q.config,
           is_required: q.is_required,
         };
       });
@@ -149,11 +149,32 @@ export class QuestionsCreateComponent implements OnInit {
 
   // Optional: Basic validation check
   isFormValid(): boolean {
-    return this.questions.every((q) => q.text && q.type);
+    return this.questions.every(
+      (q) =>
+        q.text &&
+        q.type &&
+        (!this.hasChoices(q) ||
+          ((q.config.choices || []).length > 0 &&
+            (q.config.choices || []).every((c) => c.label.trim())))
+    );
     // Add more complex validation as needed
   }
 
+  // Resets the type-specific settings when the question type changes
   clearOptions(idx: number) {
-    this.questions[idx].extra_info = '';
+    const question = this.questions[idx];
+    question.config = this.hasChoices(question) ? { choices: [{ label: '' }] } : {};
+  }
+
+  hasChoices(question: Question): boolean {
+    return ['select', 'radio', 'checkbox', 'multiselect'].includes(question.type);
+  }
+
+  addChoice(question: Question): void {
+    question.config.choices = [...(question.config.choices || []), { label: '' }];
+  }
+
+  removeChoice(question: Question, choiceIdx: number): void {
+    question.config.choices?.splice(choiceIdx, 1);
   }
 }
@@ -9,6 +9,7 @@ import {
   FormService,
   Form,
   Question,
+  Choice,
   FormResponse,
   NewFormResponse,
 } from '../../services/gforms-backend.service'; // Adjust path if needed
@@ -96,17 +97,15 @@ export class ResponsesNewComponent implements OnInit, OnDestroy {
 
     this.form.questions.forEach((question) => {
       if (question.type === 'checkbox') {
-        // For checkboxes, create a FormGroup with a control for each option
-        const options = (question.extra_info || '')
-          .split(',')
-          .map((opt) => opt.trim())
-          .filter(opt => opt.length > 0);
-        
+        // For checkboxes, create a FormGroup with a control for each option, keyed by choice ID
         const group: { [key: string]: FormControl } = {};
-        options.forEach((option) => {
-          group[option] = new FormControl(false);
+        this.getChoices(question).forEach((option) => {
+          group[option.id!] = new FormControl(false);
         });
         this.QuestionsForm.addControl(question.id, new FormGroup(group));
+      } else if (question.type === 'multiselect') {
+        // A multiple select holds the array of selected choice IDs
+        this.QuestionsForm.addControl(question.id, new FormControl<string[]>([]));
       } else {
         // For other types, create a single FormControl
         let defaultValue = '';
@@ -136,29 +135,25 @@ export class ResponsesNewComponent implements OnInit, OnDestroy {
 
     this.form!.questions.forEach((question) => {
       const control = this.QuestionsForm.get(question.id);
-      let value = '';
 
       if (question.type === 'checkbox') {
-        // For checkboxes, collect all selected options
+        // For checkboxes, collect the IDs of all selected options
         const checkboxGroup = control as FormGroup;
         const selectedOptions: string[] = [];
-        
+
         Object.keys(checkboxGroup.controls).forEach(option => {
           if (checkboxGroup.get(option)?.value === true) {
             selectedOptions.push(option);
           }
         });
-        
-        value = selectedOptions.join(', ');
+
+        nfr.answers?.push({ question_id: question.id, values: selectedOptions });
+      } else if (question.type === 'multiselect') {
+        nfr.answers?.push({ question_id: question.id, values: control?.value || [] });
       } else {
         // For other types, get the direct value
-        value = String(control?.value || '');
+        nfr.answers?.push({ question_id: question.id, value: String(control?.value || '') });
       }
-
-      nfr.answers?.push({
-        question_id: question.id,
-        value: value,
-      });
     });
 
     console.log('Submitting form response:', nfr);
@@ -176,13 +171,9 @@ export class ResponsesNewComponent implements OnInit, OnDestroy {
     });
   }
 
-  // Helper method to get checkbox options for template
-  getCheckboxOptions(question: Question): string[] {
-    if (question.type !== 'checkbox') return [];
-    return (question.extra_info || '')
-      .split(',')
-      .map((opt) => opt.trim())
-      .filter(opt => opt.length > 0);
+  // Helper method to get the options of a choice question for template
+  getChoices(question: Question): Choice[] {
+    return question.config?.choices || [];
   }
 
   // Helper method to check if a checkbox option is selected
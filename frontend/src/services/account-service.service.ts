@@ -37,6 +37,7 @@ export interface AuthResponse {
   email?: string;
   message: string;
   error?: string;
+  two_factor_required?: boolean; // The password was right, a code of the authenticator app is needed
 }
 
 @Injectable({
@@ -84,6 +85,24 @@ export class AccountService {
       );
   }
 
+  /**
+   * POST: Complete a sign in that answered two_factor_required, with a code of the
+   * authenticator app or a recovery code.
+   */
+  signinTwoFactor(code: string): Observable<AuthResponse> {
+    const url = `${this.apiUrl}/signin/2fa`;
+    return this.http
+      .post<AuthResponse>(url, { code }, this.httpOptions)
+      .pipe(
+        tap((response) => {
+          if (response.username) {
+            this.currentUserSource.next(response.username);
+          }
+        }),
+        catchError(this.handleError)
+      );
+  }
+
   /**
    * POST: Sign up a new user.
    * @param userData User's registration details.
@@ -150,6 +169,50 @@ export class AccountService {
     );
   }
 
+  /**
+   * POST: Send a new verification link, replacing the previous ones.
+   * A link is sent at most once a minute per account, and a few times an hour per client.
+   */
+  resendVerification(email: string): Observable<AuthResponse> {
+    const url = `${this.apiUrl}/verify/resend`;
+    return this.http
+      .post<AuthResponse>(url, { email }, this.httpOptions)
+      .pipe(catchError(this.handleError));
+  }
+
+  /**
+   * POST: Request a password reset link for an email address.
+   * The backend answers the same whether or not an account uses the email,
+   * and 429 when too many resets were requested from this address.
+   */
+  forgotPassword(email: string): Observable<AuthResponse> {
+    const url = `${this.apiUrl}/password/forgot`;
+    return this.http
+      .post<AuthResponse>(url, { email }, this.httpOptions)
+      .pipe(catchError(this.handleError));
+  }
+
+  /**
+   * POST: Choose a new password with the token of a reset link.
+   * Every session of the user is signed out and their API tokens revoked.
+   */
+  resetPassword(token: string, password: string): Observable<AuthResponse> {
+    const url = `${this.apiUrl}/password/reset`;
+    return this.http
+      .post<AuthResponse>(url, { token, password }, this.httpOptions)
+      .pipe(catchError(this.handleError));
+  }
+
+  /**
+   * GET: Whether single sign-on is configured, and the URL that starts it.
+   */
+  singleSignOn(): Observable<{ enabled: boolean; login_url?: string }> {
+    const url = `${this.apiUrl}/oidc`;
+    return this.http
+      .get<{ enabled: boolean; login_url?: string }>(url, this.httpOptions)
+      .pipe(catchError(this.handleError));
+  }
+
   // --- Error Handling ---
   private handleError(error: HttpErrorResponse) {
     let errorMessage = 'An unknown error occurred during authentication!';
@@ -170,31 +233,30 @@ export class AccountService {
     if (error.status === 401) {
       userFriendlyMessage =
         'Invalid credentials. Please check your email and password.';
+    } else if (error.status === 400 && error.error?.error) {
+      // Validation errors, such as a password that doesn't follow the password policy
+      userFriendlyMessage = error.error.error;
+    } else if (error.status === 429) {
+      userFriendlyMessage = 'Too many attempts. Please wait a few minutes and try again.';
     } else if (error.status === 409) {
-      // Example: Conflict for signup if email exists
-      userFriendlyMessage = 'This email address is already registered.';
+      // Conflict for signup if the email or the username exists
+      userFriendlyMessage = error.error?.error || 'This email address is already registered.';
     }
     return throwError(() => new Error(userFriendlyMessage));
   }
   // --- Helper to get initial user state (e.g., from storage) ---
   private getInitialUser(): string | null {
-    const sessionCookie = document.cookie
-      .split(';')
-      .map((cookie) => cookie.trim())
-      .find((cookie) => cookie.startsWith('gform_session='));
-
-    if (sessionCookie) {
-      this.whoAmI().subscribe({
-        next: (response) => {
-          if (response.username) {
-            this.currentUserSource.next(response.username);
-          }
-        },
-        error: (error) => {
-          console.error('WhoAmI failed', error);
-        },
-      });
-    }
+    // The session cookie is HttpOnly: ask the backend whether it belongs to a signed in user.
+    this.whoAmI().subscribe({
+      next: (response) => {
+        if (response.username) {
+          this.currentUserSource.next(response.username);
+        }
+      },
+      error: () => {
+        // 401: nobody is signed in
+      },
+    });
 
     return null; // Default to not logged in
   }
@@ -7,22 +7,132 @@ import {
 import { Observable, throwError } from 'rxjs';
 import { catchError } from 'rxjs/operators';
 
+/**
+ * A selectable option of a choice question (select, radio, checkbox, multiselect).
+ * The id is assigned by the backend and stays stable across edits.
+ */
+export interface Choice {
+  id?: string;
+  label: string;
+}
+
+/**
+ * Type-specific settings of a question. Each question type only accepts
+ * the fields that make sense for it; the backend rejects the others.
+ */
+export interface QuestionConfig {
+  placeholder?: string;
+  choices?: Choice[];
+  min_length?: number;
+  max_length?: number;
+  pattern?: string;
+  rows?: number;
+  min?: number;
+  max?: number;
+  step?: number;
+  min_date?: string; // YYYY-MM-DD
+  max_date?: string; // YYYY-MM-DD
+  min_selections?: number;
+  max_selections?: number;
+}
+
+export type QuestionType =
+  | 'text'
+  | 'textarea'
+  | 'email'
+  | 'number'
+  | 'date'
+  | 'select'
+  | 'radio'
+  | 'checkbox'
+  | 'multiselect';
+
+export type ConditionOperator =
+  | 'answered'
+  | 'not_answered'
+  | 'equals'
+  | 'not_equals'
+  | 'contains'
+  | 'gt'
+  | 'gte'
+  | 'lt'
+  | 'lte';
+
+/**
+ * A boolean expression over the answers to earlier questions: either a
+ * group (all, any, not) or a comparison on a single question.
+ */
+export interface Condition {
+  all?: Condition[];
+  any?: Condition[];
+  not?: Condition;
+  question_id?: string; // UUID
+  op?: ConditionOperator;
+  value?: string;
+}
+
+/**
+ * Skips forward to a later question, or to the end of the form, when its condition holds.
+ */
+export interface JumpRule {
+  if: Condition;
+  to_question_id?: string; // UUID
+  to_section_id?: string; // UUID
+  to_end?: boolean;
+}
+
 /**
  * Represents a single question within a form.
  */
 export interface Question {
   description: any;
   required: any;
-  placeholder: any;
   id: string; // UUID
+  section_id?: string | null; // UUID of the page the question is on
+  position?: number; // Order of the question within its section, from 0
   text: string;
-  type: string; // Consider using a literal type if possible, e.g., 'rating' | 'text' | 'multiple_choice'
-  extra_info: string;
+  type: QuestionType | '';
+  config: QuestionConfig;
+  show_if?: Condition;
+  jumps?: JumpRule[];
   is_required: boolean;
   created_at: string | null; // ISO 8601 Date string
   updated_at: string | null; // ISO 8601 Date string
 }
 
+/**
+ * A page of a form. Every question belongs to exactly one section.
+ */
+export interface Section {
+  id: string; // UUID
+  title: string;
+  description: string;
+  position: number;
+  questions: Question[];
+  created_at: string | null; // ISO 8601 Date string
+  updated_at: string | null; // ISO 8601 Date string
+}
+
+/**
+ * Result of validating the answers to one page before moving on.
+ */
+export interface SectionCheck {
+  valid: boolean;
+  next_section_id: string | null; // null when the form ends after this page
+}
+
+/**
+ * Lifecycle state of a form. Only published forms accept responses (scheduled
+ * forms are published automatically when their window opens),
+ * and only drafts can be edited.
+ */
+export type FormStatus = 'draft' | 'scheduled' | 'published' | 'closed';
+
+/**
+ * Lifecycle transitions exposed by the backend as POST /forms/{id}/{transition}.
+ */
+export type FormTransition = 'publish' | 'unpublish' | 'close' | 'reopen';
+
 /**
  * Represents the main form structure.
  */
@@ -31,7 +141,13 @@ export interface Form {
   title: string;
   description: string;
   creator_user_id: string;
-  questions: Question[]; // Array of Question objects
+  status: FormStatus;
+  version: number;
+  opens_at: string | null; // ISO 8601 Date string
+  closes_at: string | null; // ISO 8601 Date string
+  max_responses: number | null;
+  questions: Question[]; // Every question of the form, in page order
+  sections: Section[]; // The pages of the form, with their questions
   created_at: string; // ISO 8601 Date string
   updated_at: string; // ISO 8601 Date string
 }
@@ -51,17 +167,39 @@ export interface FormResponse {
 export interface Answer {
   id: string;
   question_id: string;
-  value: string;
+  value?: string; // Single-valued questions
+  values?: string[]; // Selected choice IDs of checkbox and multiselect questions
   created_at: string;
   updated_at: string;
 }
 
 export type NewFormDto = Omit<
   Form,
-  'id' | 'created_at' | 'updated_at' | 'questions'
-> & { questions?: Omit<Question, 'id' | 'created_at' | 'updated_at'>[] };
+  | 'id'
+  | 'created_at'
+  | 'updated_at'
+  | 'questions'
+  | 'sections'
+  | 'status'
+  | 'version'
+  | 'opens_at'
+  | 'closes_at'
+  | 'max_responses'
+> &
+  Partial<Pick<Form, 'opens_at' | 'closes_at' | 'max_responses'>> & {
+    questions?: Omit<Question, 'id' | 'created_at' | 'updated_at'>[];
+  };
 export type UpdateFormDto = Partial<
-  Omit<Form, 'id' | 'creator_user_id' | 'created_at' | 'updated_at'>
+  Omit<
+    Form,
+    | 'id'
+    | 'creator_user_id'
+    | 'created_at'
+    | 'updated_at'
+    | 'status'
+    | 'version'
+    | 'sections'
+  >
 >;
 export type NewFormResponse = Omit<
   FormResponse,
@@ -150,6 +288,19 @@ export class FormService {
       .pipe(catchError(this.handleError));
   }
 
+  /**
+   * POST: Move a form through its lifecycle (publish, unpublish, close, reopen).
+   * @param id The UUID string of the form.
+   * @param transition The transition to apply.
+   * @returns Observable<Form> The form with its new status.
+   */
+  transitionForm(id: string, transition: FormTransition): Observable<Form> {
+    const url = `${this.apiUrl}/${id}/${transition}`;
+    return this.http
+      .post<Form>(url, {}, this.httpOptions)
+      .pipe(catchError(this.handleError));
+  }
+
   // --- Responses Methods ---
 
   /**
@@ -160,7 +311,7 @@ export class FormService {
   getFormResponses(formId: string): Observable<FormResponse[]> {
     const url = `${this.apiUrl}/${formId}/responses`;
     return this.http
-      .get<FormResponse[]>(url)
+      .get<FormResponse[]>(url, this.httpOptions)
       .pipe(catchError(this.handleError));
   }
 
@@ -179,6 +330,68 @@ export class FormService {
       .pipe(catchError(this.handleError));
   }
 
+  /**
+   * PUT: Replace the pages of a form and their questions.
+   * @param formId The UUID string of the form.
+   * @param sections The sections, in order, each with its questions.
+   */
+  updateSections(formId: string, sections: Partial<Section>[]) {
+    const url = `${this.apiUrl}/${formId}/questions`;
+    return this.http
+      .put<Form>(url, { sections }, this.httpOptions)
+      .pipe(catchError(this.handleError));
+  }
+
+  /**
+   * POST: Validate the answers to one page before moving on to the next one.
+   * A 400 response lists the invalid answers in `fields`.
+   * @param formId The UUID string of the form.
+   * @param sectionId The UUID string of the page being left.
+   * @param answers Every answer given so far.
+   */
+  validateSection(
+    formId: string,
+    sectionId: string,
+    answers: Omit<Answer, 'id' | 'created_at' | 'updated_at'>[]
+  ): Observable<SectionCheck> {
+    const url = `${this.apiUrl}/${formId}/sections/${sectionId}/validate`;
+    return this.http.post<SectionCheck>(url, { answers }, this.httpOptions);
+  }
+
+  /**
+   * PATCH: Move a question to a position within its section, or into another section.
+   * @param formId The UUID string of the form.
+   * @param questionId The UUID string of the question to move.
+   * @param position The new position, from 0.
+   * @param sectionId The section to move the question to, if it changes.
+   * @returns Observable<Form> The form with its questions in their new order.
+   */
+  moveQuestion(
+    formId: string,
+    questionId: string,
+    position: number,
+    sectionId?: string
+  ): Observable<Form> {
+    const url = `${this.apiUrl}/${formId}/questions/${questionId}/move`;
+    return this.http
+      .patch<Form>(url, { position, section_id: sectionId }, this.httpOptions)
+      .pipe(catchError(this.handleError));
+  }
+
+  /**
+   * PATCH: Move a section, with its questions, to a new position.
+   * @param formId The UUID string of the form.
+   * @param sectionId The UUID string of the section to move.
+   * @param position The new position, from 0.
+   * @returns Observable<Form> The form with its sections in their new order.
+   */
+  moveSection(formId: string, sectionId: string, position: number): Observable<Form> {
+    const url = `${this.apiUrl}/${formId}/sections/${sectionId}/move`;
+    return this.http
+      .patch<Form>(url, { position }, this.httpOptions)
+      .pipe(catchError(this.handleError));
+  }
+
   updateQuestions(formId: string, questions: Question[]) {
     const url = `${this.apiUrl}/${formId}/questions`;
     return this.http